@@ -0,0 +1,237 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"encoding/json"
+	"github.com/ziscky/zist/zistrpc"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+//REST API addressed by process name, which unlike the pid survives restarts.
+//Requests carry the zistd Token as "Authorization: Bearer <Token>" and
+//errors come back as {"error": {"code": ..., "message": ...}}
+
+//Error codes only the REST API returns, the others are the zistrpc codes
+const (
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeMethodNotAllowed = "method_not_allowed"
+)
+
+//apiStatus maps error codes to HTTP status codes
+var apiStatus = map[string]int{
+	zistrpc.CodeNotFound: http.StatusNotFound,
+	zistrpc.CodeInvalid:  http.StatusBadRequest,
+	zistrpc.CodeConflict: http.StatusConflict,
+	zistrpc.CodeFailed:   http.StatusInternalServerError,
+	codeUnauthorized:     http.StatusUnauthorized,
+	codeForbidden:        http.StatusForbidden,
+	codeMethodNotAllowed: http.StatusMethodNotAllowed,
+}
+
+//signalRequest is the body of POST .../signal
+type signalRequest struct {
+	Signal string `json:"signal"`
+}
+
+//APIRoutes registers the /api/v1 routes on the router
+func APIRoutes(router *mux.Router) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/processes", Allow("GET", CheckAPIToken(APIProcesses)))
+	api.HandleFunc("/processes/{name}", Allow("GET", CheckAPIToken(WithName(APIStatus))))
+	api.HandleFunc("/processes/{name}/start", Allow("POST", CheckAPIToken(WithName(APIStart))))
+	api.HandleFunc("/processes/{name}/stop", Allow("POST", CheckAPIToken(WithName(APIStop))))
+	api.HandleFunc("/processes/{name}/restart", Allow("POST", CheckAPIToken(WithName(APIRestart))))
+	api.HandleFunc("/processes/{name}/signal", Allow("POST", CheckAPIToken(WithName(APISignal))))
+	api.HandleFunc("/processes/{name}/logs", Allow("GET", CheckAPIToken(WithName(APILogs))))
+	api.NotFoundHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeNotFound, "No such resource %s", r.URL.Path))
+	})
+}
+
+//Allow only lets requests with the given method through.
+//Route.Methods isn't used since mux answers mismatches on a subrouter with 404
+func Allow(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			rw.Header().Set("Allow", method)
+			writeAPIError(rw, zistrpc.Errorf(codeMethodNotAllowed, "%s not allowed on %s", r.Method, r.URL.Path))
+			return
+		}
+		next(rw, r)
+	}
+}
+
+//writeAPI writes v as the JSON body of the response
+func writeAPI(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Println("api:", err)
+	}
+}
+
+//writeAPIError writes err as a JSON error body with the HTTP status of its code
+func writeAPIError(rw http.ResponseWriter, err error) {
+	e := zistrpc.ParseError(err)
+	status, ok := apiStatus[e.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeAPI(rw, status, map[string]*zistrpc.Error{"error": e})
+}
+
+//CheckAPIToken checks the bearer token of API requests
+func CheckAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !secureEqual(bearerToken(r), appConf.Token) {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="zistd"`)
+			writeAPIError(rw, zistrpc.Errorf(codeUnauthorized, "Invalid or missing token"))
+			return
+		}
+		next(rw, r)
+	}
+}
+
+//WithName adds the process named in the route to the request vars
+func WithName(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		proc, err := findProcess(mux.Vars(r)["name"])
+		if err != nil {
+			writeAPIError(rw, err)
+			return
+		}
+		StoreVar(r, "proc", proc)
+		defer RemoveVars(r)
+		next(rw, r)
+	}
+}
+
+//APIProcesses lists all monitored processes
+func APIProcesses(rw http.ResponseWriter, r *http.Request) {
+	procs := []zistrpc.ProcessInfo{}
+	for _, proc := range jobProcesses("") {
+		procs = append(procs, proc.Info())
+	}
+	writeAPI(rw, http.StatusOK, procs)
+}
+
+//APIStatus gets the process info with its masked environment
+func APIStatus(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	info := proc.Info()
+	info.Env = MaskEnv(proc.Environ())
+	writeAPI(rw, http.StatusOK, info)
+}
+
+//APIStart starts a stopped process, 409 if it is running or waiting to restart
+func APIStart(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	if err := startStopped(proc); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	log.Println(proc.Pname, "started with pid", proc.PID)
+	writeAPI(rw, http.StatusOK, proc.Info())
+}
+
+//APIStop stops the process and waits for it to exit
+func APIStop(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	if err := proc.Kill(); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	log.Println(proc.Pname, "stopped")
+	writeAPI(rw, http.StatusOK, proc.Info())
+}
+
+//APIRestart stops the process and starts it again
+func APIRestart(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	if err := restartProcess(proc); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	log.Println(proc.Pname, "restarted with pid", proc.PID)
+	writeAPI(rw, http.StatusOK, proc.Info())
+}
+
+//APISignal sends the signal in the {"signal": "HUP"} body to the running process
+func APISignal(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	var req signalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeInvalid, "Invalid body: %s", err))
+		return
+	}
+	if req.Signal == "" {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeInvalid, "signal is required"))
+		return
+	}
+	sig, err := ParseSignal(req.Signal)
+	if err != nil {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeInvalid, "Unknown signal %s", req.Signal))
+		return
+	}
+	proc.runLock.Lock()
+	defer proc.runLock.Unlock()
+	if !proc.Running() {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeConflict, "%s is %s", proc.Pname, proc.currentState()))
+		return
+	}
+	if err := proc.signal(sig); err != nil {
+		writeAPIError(rw, err)
+		return
+	}
+	log.Println(proc.Pname, "sent", sig)
+	writeAPI(rw, http.StatusOK, proc.Info())
+}
+
+//APILogs gets the merged, time ordered stdout and stderr of the process.
+//?from= and ?to= take RFC3339 times or durations ago i.e 15m, ?match= a regular expression
+func APILogs(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	if !proc.EStdOut && !proc.EStdErr {
+		writeAPIError(rw, zistrpc.Errorf(codeForbidden, "Output of %s is not shared over the web API", proc.Pname))
+		return
+	}
+	from, err := ParseLogTime(r.FormValue("from"))
+	if err != nil {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeInvalid, "from: %s", err))
+		return
+	}
+	to, err := ParseLogTime(r.FormValue("to"))
+	if err != nil {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeInvalid, "to: %s", err))
+		return
+	}
+	lines, err := proc.Logs(LogFilter{From: from, To: to, Match: r.FormValue("match"), Stdout: proc.EStdOut, Stderr: proc.EStdErr})
+	if err != nil {
+		writeAPIError(rw, zistrpc.Errorf(zistrpc.CodeInvalid, "%s", err))
+		return
+	}
+	if lines == nil {
+		lines = []zistrpc.LogLine{}
+	}
+	writeAPI(rw, http.StatusOK, zistrpc.LogReply{Lines: lines})
+}
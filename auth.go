@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//RPCAuth only lets connections carrying the zistd Token as
+//"Authorization: Bearer <Token>" on their CONNECT request through to the RPC server,
+//so every call made on a connection is authenticated
+func RPCAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !secureEqual(bearerToken(r), appConf.Token) {
+			log.Println("Rejected unauthenticated RPC connection from", r.RemoteAddr)
+			http.Error(rw, "zistd: invalid or missing RPC token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+//bearerToken gets the token of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+//secureEqual compares secrets in constant time, an empty secret never matches
+func secureEqual(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
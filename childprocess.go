@@ -18,25 +18,27 @@ Copyright (C) 2016  Eric Ziscky
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
+	"github.com/ziscky/zist/zistrpc"
 	"io"
 	"log"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 //ChildProcess keeps information about all child processes spawned from the config files
 type ChildProcess struct {
-	PID     int
-	Pname   string
-	PPath   string
-	IsAlive bool
-	Args    string
+	PID   int
+	Pname string
+	PPath string
+	State ProcessState
+	Args  string
+	//Conf is the job the process was started from, reused on restarts
+	Conf Job
 	//output endpoints for the process are enabled/disable
 	EStdErr bool
 	EStdOut bool
@@ -48,17 +50,48 @@ type ChildProcess struct {
 	StdErrWr *io.PipeWriter
 	StdErrR  *io.PipeReader
 	//Stderr and Stdout storage
-	Errors []string
-	Output []string
+	Errors *RingBuffer
+	Output *RingBuffer
+	//History is the sampled resource usage of the process tree
+	History    *MetricsHistory
+	lastSample *zistrpc.ProcStats
+	//Stdout and stderr log files, the same file when the job has no ErrLogfile
+	outLog *RotatingFile
+	errLog *RotatingFile
+	//syslog forwards the output when the job has a Syslog table
+	syslog *SyslogWriter
 	//time started
 	Timestamp time.Time
 	//Used to check if process crashed or killed through the api
 	KillSwitch bool
 	//Number of times restarted
 	RestartCount int
+	//Generation counts the runs of the process, it tags the captured output
+	Generation int
 	//When process returns, for checking if detachment
 	DetachF bool
-	lock    sync.RWMutex //for the stdout and stderr storage
+	//Signal sent on stop and the grace period before SIGKILL
+	StopSignal  syscall.Signal
+	StopTimeout time.Duration
+	//LastExit is how the process last exited, nil if it hasn't
+	LastExit *zistrpc.ExitStatus
+	//Consecutive failed starts and when the next restart is due, zero if none is pending
+	Failures    int
+	NextAttempt time.Time
+	//Health is the liveness of the process from its health check, HealthFailures counts
+	//the failed probes in a row and HealthFailuresTotal all of them
+	Health              string
+	HealthFailures      int
+	HealthFailuresTotal int
+	LastProbe           *zistrpc.ProbeResult
+	LastReadiness       *zistrpc.ProbeResult
+	recycle             bool          //restart whatever the restart policy
+	env                 []string      //environment of the current run
+	exited              chan struct{} //closed when the current run of the process exits
+	done                chan struct{} //closed when the supervisor returns
+	halt                chan struct{} //cancels a pending restart
+	runLock             sync.Mutex    //serializes restarts with Kill, guards KillSwitch, DetachF, recycle, done and halt
+	stateLock           sync.Mutex    //guards State and the other fields Info reads that change while the process runs
 }
 
 //Initialize creates the process instance
@@ -69,31 +102,83 @@ func (cp *ChildProcess) Initialize(ps Job, numrestarts int) error {
 	if err := os.Chdir(wd); err != nil {
 		return err
 	}
-	if len(ps.Args) < 1 {
-		cp.Proc = exec.Command(wd + bname)
-	} else {
-		log.Println(ps.Args)
-		cp.Proc = exec.Command(wd+bname, ps.Args)
+	args, err := ps.argv()
+	if err != nil {
+		return err
+	}
+	cp.Proc = exec.Command(wd+bname, args...)
+	if cp.Proc.Env, err = ps.environ(); err != nil {
+		return err
+	}
+	cred, err := ps.credential()
+	if err != nil {
+		return err
+	}
+	//own process group so stopping the process also stops what it started
+	cp.Proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+	//don't let processes it left behind holding stdout and stderr keep Wait from returning
+	cp.Proc.WaitDelay = OutputWaitDelay
+	if cp.outLog == nil && cp.errLog == nil {
+		if cp.outLog, cp.errLog, err = ps.openLogs(); err != nil {
+			return err
+		}
+	}
+	if cp.syslog == nil {
+		if cp.syslog, err = ps.openSyslog(); err != nil {
+			return err
+		}
 	}
 	cp.StdOutR, cp.StdOutWr = io.Pipe()
 	cp.StdErrR, cp.StdErrWr = io.Pipe()
 	cp.Proc.Stdout = cp.StdOutWr
 	cp.Proc.Stderr = cp.StdErrWr
-	cp.RestartCount += numrestarts
 	if ps.Workingdir != "" {
 		cp.Proc.Dir = ps.Workingdir
 	} else {
 		cp.Proc.Dir = wd
 	}
+	cp.stateLock.Lock()
+	cp.RestartCount += numrestarts
+	cp.stateLock.Unlock()
 	if err := cp.Proc.Start(); err != nil {
 		return err
 	}
+	cp.stateLock.Lock()
 	cp.PID = cp.Proc.Process.Pid
+	cp.Generation++
 	cp.Timestamp = time.Now()
-	cp.IsAlive = true
+	cp.State = StateStarting
+	cp.exited = make(chan struct{})
+	cp.env = cp.Proc.Env
+	cp.Health, cp.HealthFailures = "", 0
+	if ps.HealthCheck != nil {
+		cp.Health = HealthUnknown
+	}
+	stdout := OutputStream{"stdout", cp.StdOutR, cp.Output, cp.outLog, cp.syslog, cp.PID, cp.Generation}
+	stderr := OutputStream{"stderr", cp.StdErrR, cp.Errors, cp.errLog, cp.syslog, cp.PID, cp.Generation}
+	cp.stateLock.Unlock()
+	//Start logging the process stdout and stderr
+	go LogStream(stdout)
+	go LogStream(stderr)
+	if ps.HealthCheck != nil {
+		go cp.monitorHealth(ps.HealthCheck, cp.exited)
+	}
+	if ps.hasLimits() {
+		go cp.monitorLimits(ps, cp.exited)
+	}
+	go cp.monitorReadiness(ps, cp.exited)
 	return nil
 }
 
+//workingDir returns the directory the job's process runs in
+func (job Job) workingDir() string {
+	if job.Workingdir != "" {
+		return job.Workingdir
+	}
+	wd, _ := getWD(job.Path)
+	return wd
+}
+
 //getWD  gets the working directory to the process context
 func getWD(path string) (string, string) {
 	tree := strings.Split(path, "/")
@@ -103,93 +188,229 @@ func getWD(path string) (string, string) {
 }
 
 //Kill stops the process with the KillSwitch flag
+//sends the stop signal and only sends SIGKILL if the process
+//hasn't exited within the stop timeout. A pending restart is cancelled.
+//Returns once the supervisor has let go of the process
 func (cp *ChildProcess) Kill() error {
+	cp.runLock.Lock()
 	cp.KillSwitch = true
-	return cp.Proc.Process.Kill()
+	if cp.Running() {
+		cp.setState(StateStopping)
+	}
+	err := cp.signalGroup(cp.StopSignal)
+	halt, done := cp.halt, cp.done
+	cp.runLock.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case halt <- struct{}{}:
+	default:
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(cp.StopTimeout):
+		log.Println(cp.Pname, "did not exit after", cp.StopTimeout, "sending SIGKILL")
+		if err := cp.signalGroup(syscall.SIGKILL); err != nil {
+			return err
+		}
+		<-done
+		return nil
+	}
 }
 
-//Stats gets the cpu and memory usage of a process
-func (cp *ChildProcess) Stats() (map[string]string, error) {
-	stats, err := exec.Command("ps", "-p", strconv.Itoa(cp.PID), "-o", "%cpu,%mem").Output()
+//recordExit stores the exit status of the process once it has been waited on
+func (cp *ChildProcess) recordExit() *zistrpc.ExitStatus {
+	status := &zistrpc.ExitStatus{Code: -1, Time: time.Now()}
+	if cp.Proc.ProcessState != nil {
+		ws := cp.Proc.ProcessState.Sys().(syscall.WaitStatus)
+		if ws.Signaled() {
+			status.Signal = ws.Signal().String()
+		} else {
+			status.Code = ws.ExitStatus()
+		}
+	}
+	cp.stateLock.Lock()
+	cp.LastExit = status
+	cp.stateLock.Unlock()
+	return status
+}
+
+//Recycle restarts the process through the graceful stop path whatever the restart policy
+func (cp *ChildProcess) Recycle(reason string) {
+	cp.runLock.Lock()
+	if cp.KillSwitch {
+		cp.runLock.Unlock()
+		return
+	}
+	log.Println(cp.Pname, "restarting,", reason)
+	cp.recycle = true
+	cp.setState(StateStopping)
+	exited := cp.exited
+	err := cp.signalGroup(cp.StopSignal)
+	cp.runLock.Unlock()
 	if err != nil {
-		return map[string]string{}, err
+		log.Println(cp.Pname, err)
+		return
+	}
+	select {
+	case <-exited:
+	case <-time.After(cp.StopTimeout):
+		log.Println(cp.Pname, "did not exit after", cp.StopTimeout, "sending SIGKILL")
+		cp.signalGroup(syscall.SIGKILL)
+	}
+}
+
+//signal sends sig to the process, a process that already exited is not an error
+func (cp *ChildProcess) signal(sig syscall.Signal) error {
+	if cp.Proc == nil || cp.Proc.Process == nil {
+		return nil
+	}
+	if err := cp.Proc.Process.Signal(sig); err != nil && err != os.ErrProcessDone {
+		return err
 	}
-	statsStr := strings.Trim(strings.SplitAfter(string(stats), "\n")[1], " ")
-	l := strings.SplitAfter(statsStr, " ")
+	return nil
+}
 
-	return map[string]string{
-		"cpu": l[0],
-		"mem": strings.Replace(l[2], "\n", "", -1),
-	}, nil
+//signalGroup sends sig to the process group of the process, which holds the processes it started
+//unless they moved to their own group. A group that is already gone is not an error
+func (cp *ChildProcess) signalGroup(sig syscall.Signal) error {
+	if cp.Proc == nil || cp.Proc.Process == nil {
+		return nil
+	}
+	if err := syscall.Kill(-cp.Proc.Process.Pid, sig); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
 }
 
-//GetErrors gets the current errors from the process stderr
-func (cp *ChildProcess) GetErrors() []string {
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
-	return cp.Errors
+//Supervised checks if the process is running or waiting to be restarted
+func (cp *ChildProcess) Supervised() bool {
+	cp.runLock.Lock()
+	defer cp.runLock.Unlock()
+	return cp.supervised()
 }
 
-//GetOutput gets the stdout of the process
-func (cp *ChildProcess) GetOutput() []string {
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
-	return cp.Output
+//supervised is Supervised for callers holding runLock
+func (cp *ChildProcess) supervised() bool {
+	select {
+	case <-cp.done:
+		return false
+	default:
+		return true
+	}
 }
 
-//AppendError stores error info from the stderr of the process
-//to the internal slice
-func (cp *ChildProcess) AppendError(errorStr string) {
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
-	cp.Errors = append(cp.Errors, errorStr)
+//Environ returns the environment of the current run of the process
+func (cp *ChildProcess) Environ() []string {
+	cp.stateLock.Lock()
+	defer cp.stateLock.Unlock()
+	return cp.env
 }
 
-//AppendOutput stores stdout info from the stdout of the process
-//to the internal slice
-func (cp *ChildProcess) AppendOutput(outputStr string) {
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
-	cp.Output = append(cp.Output, outputStr)
+//Info summarizes the process for the RPC and web API listings
+func (cp *ChildProcess) Info() zistrpc.ProcessInfo {
+	cp.stateLock.Lock()
+	defer cp.stateLock.Unlock()
+	info := zistrpc.ProcessInfo{
+		PID:         cp.PID,
+		Name:        cp.Pname,
+		Path:        cp.PPath,
+		Args:        cp.Args,
+		NumRestarts: cp.RestartCount,
+		Generation:  cp.Generation,
+		TimeStarted: cp.Timestamp.String(),
+		TimeAlive:   time.Since(cp.Timestamp).String(),
+		State:       string(cp.State),
+		Failures:    cp.Failures,
+		LastExit:    cp.LastExit,
+		Health:      cp.Health,
+		LastProbe:   cp.LastProbe,
+		Readiness:   cp.LastReadiness,
+	}
+	if !cp.NextAttempt.IsZero() {
+		info.NextAttempt = cp.NextAttempt.String()
+	}
+	return info
 }
 
-//ClearErrorBuff clears the process error buffer with an option to store it to a file
-func (cp *ChildProcess) ClearErrorBuff(store int) {
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
-	if store > 0 {
-		file, err := os.OpenFile("stderr", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
-		if err != nil {
-			fmt.Println("[*] Error writing error buffer to file. Ensure correct permissions")
-		}
-		errbytes, _ := json.Marshal(cp.Errors)
-		if _, err := io.WriteString(file, string(errbytes)); err != nil {
-			fmt.Println("[*] Error writing error buffer to file. Ensure correct permissions")
-		}
+//Stats gets the resource usage of a process and its descendants from /proc,
+//with the sum of the whole tree in Total. Cpu usage is sampled over StatsWindow
+func (cp *ChildProcess) Stats() (*zistrpc.ProcStats, error) {
+	if state := cp.currentState(); !state.Running() {
+		return nil, errors.New("[*] " + cp.Pname + " is " + string(state))
+	}
+	prev, err := ReadProcTree(cp.PID)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(StatsWindow)
+	stats, err := ReadProcTree(cp.PID)
+	if err != nil {
+		return nil, err
 	}
-	cp.Errors = cp.Errors[:0]
+	stats.TreeCPUPercentSince(prev)
+	stats.SumTree()
+	return stats, nil
 }
 
-//ClearStdoutBuff clears the process stdout buffer with an option to store it to a file
-func (cp *ChildProcess) ClearStdoutBuff(store int) {
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
-	if store > 0 {
-		file, err := os.Open("stdout")
-		if err != nil {
-			fmt.Println("[*] Error writing stdout buffer to file. Ensure correct permissions")
-		}
-		errbytes, _ := json.Marshal(cp.Errors)
-		if _, err := io.WriteString(file, string(errbytes)); err != nil {
-			fmt.Println("[*] Error writing stdout buffer to file. Ensure correct permissions")
+//GetErrors gets the stderr lines of the process after sequence number since
+func (cp *ChildProcess) GetErrors(since uint64) []zistrpc.LogLine {
+	return cp.Errors.Since(since)
+}
+
+//GetOutput gets the stdout lines of the process after sequence number since
+func (cp *ChildProcess) GetOutput(since uint64) []zistrpc.LogLine {
+	return cp.Output.Since(since)
+}
+
+//ClearErrorBuff clears the process error buffer, the lines stay in the job Logfile if one is set
+func (cp *ChildProcess) ClearErrorBuff() {
+	cp.Errors.Clear()
+}
+
+//ClearStdoutBuff clears the process stdout buffer, the lines stay in the job Logfile if one is set
+func (cp *ChildProcess) ClearStdoutBuff() {
+	cp.Output.Clear()
+}
+
+//RotateLogs rotates the process stdout and stderr log files
+func (cp *ChildProcess) RotateLogs() error {
+	if cp.outLog == nil && cp.errLog == nil {
+		return errors.New("No Logfile configured for " + cp.Pname)
+	}
+	if cp.outLog != nil {
+		if err := cp.outLog.Rotate(); err != nil {
+			return err
 		}
 	}
-	cp.Output = cp.Output[:0]
+	if cp.errLog != nil && cp.errLog != cp.outLog {
+		return cp.errLog.Rotate()
+	}
+	return nil
+}
+
+//closeLogs closes the process log files and syslog connection, they are opened again on the next start
+func (cp *ChildProcess) closeLogs() {
+	if cp.outLog != nil {
+		cp.outLog.Close()
+	}
+	if cp.errLog != nil {
+		cp.errLog.Close()
+	}
+	cp.outLog, cp.errLog = nil, nil
+	if cp.syslog != nil {
+		cp.syslog.Close()
+		cp.syslog = nil
+	}
 }
 
 //Detach disowns the child process
 func (cp *ChildProcess) Detach() error {
+	cp.runLock.Lock()
 	cp.DetachF = true
+	cp.runLock.Unlock()
 	cp.Kill()
 	RemoveProcess(cp)
 	cmd := exec.Command(cp.PPath, "&")
@@ -26,6 +26,9 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
 )
 
 //ZistConfig stores the zistd configuration info
@@ -36,22 +39,109 @@ type ZistConfig struct {
 	HTTPPort int
 	RPCPort  int
 	Token    string
+	//SecretPattern matches env var names masked in process status
+	SecretPattern string
+	//StatsInterval is the seconds between samples of the stats history (default 10, -1 turns it off)
+	//and StatsHistory the hours it's kept (default 24)
+	StatsInterval int
+	StatsHistory  int
+	//MetricsAuth protects /metrics: token (default, Authorization: Bearer <Token>),
+	//basic (MetricsUser and MetricsPassword) or none
+	MetricsAuth     string
+	MetricsUser     string
+	MetricsPassword string
+	//RPCCertFile and RPCKeyFile serve RPC over TLS, RPCClientCAFile also requires
+	//zistcl to present a client certificate signed by it
+	RPCCertFile     string
+	RPCKeyFile      string
+	RPCClientCAFile string
+	//Socket is the unix socket local zistcl connects to (default /run/zist/zistd.sock, none turns it off).
+	//Root, zistd's user, SocketOwner and callers in SocketGroup may use it, SocketMode defaults to 0660
+	Socket      string
+	SocketOwner string
+	SocketGroup string
+	SocketMode  string
 }
 
 var appConf ZistConfig
 
 //Job represents the structure of monitored processes
 type Job struct {
-	Name       string
-	Path       string
-	Args       string
+	Name string
+	Path string
+	Args string
+	//Argv is the argument list as a toml array, used instead of Args when set
+	Argv       []string
 	Workingdir string
+	//Logfile receives the process stdout, and stderr unless ErrLogfile is set.
+	//Relative paths are resolved from the working directory of the process
 	Logfile    string
-	Web        bool
-	Restart    bool
+	ErrLogfile string
+	//LogMaxSize in MB and LogMaxAge in hours rotate the log files, LogBackups rotated files
+	//are kept (default 5) and gzipped with LogCompress
+	LogMaxSize  int
+	LogMaxAge   int
+	LogBackups  int
+	LogCompress bool
+	//BufferLines and BufferBytes limit the stdout and stderr lines kept in memory for the API,
+	//1000 lines each when neither is set
+	BufferLines int
+	BufferBytes int
+	//Syslog forwards stdout and stderr to a syslog daemon
+	Syslog *SyslogConfig
+	Web    bool
+	//Restart is the restart policy: always, on-failure or never
+	Restart RestartPolicy
+	//ExpectedExitCodes are the clean exit codes not restarted by on-failure, default [0]
+	ExpectedExitCodes []int
+	//StartSecs is how long the process must stay up for a start to count as successful
+	StartSecs int
+	//MaxRetries is the number of consecutive failed starts retried before giving up, -1 is unlimited
+	MaxRetries int
+	//Backoff and MaxBackoff are the first and the longest delay in seconds between failed starts
+	Backoff    int
+	MaxBackoff int
+	//StopSignal is sent to stop the process i.e TERM, INT, QUIT, HUP, USR1
+	StopSignal string
+	//StopTimeout is the seconds to wait for the process to exit before SIGKILL
+	StopTimeout int
+	//Env sets environment variables for the process
+	Env map[string]string
+	//EnvFile is a dotenv file, relative paths are resolved from Confdir
+	EnvFile string
+	//ClearEnv starts the process without inheriting zistd's environment
+	ClearEnv bool
+	//HealthCheck probes the process and restarts it when it keeps failing
+	HealthCheck *Probe
+	//ReadinessCheck marks the process ready once it passes, without it the process
+	//is ready after StartSecs
+	ReadinessCheck *Probe
+	//MaxMemory in MB restarts the process when its tree uses more resident memory
+	MaxMemory int
+	//MaxCPU restarts the process when its tree uses more cpu percent, 100 is a whole core,
+	//for MaxCPUSecs seconds in a row (default 60)
+	MaxCPU     float64
+	MaxCPUSecs int
+	//DependsOn names the jobs that must be ready before this one starts
+	DependsOn []string
+	//Priority orders jobs that don't depend on each other, lower starts first and stops last.
+	//A job starts once the jobs with a lower Priority are ready, unless they depend on it
+	Priority int
+	//NumProcs is the number of identical instances to run, Name must then contain %(index)d
+	NumProcs int
+	//Template is the unexpanded Name and Index the instance index of an instance job
+	Template string `toml:"-"`
+	Index    int    `toml:"-"`
+	//User and Group the process runs as, by name or id
+	User                string
+	Group               string
+	SupplementaryGroups []string
 }
 
-var jobs []Job
+var (
+	jobs     []Job
+	jobsLock sync.RWMutex //guards jobs, reloads replace them while the starters and scaling read them
+)
 
 //BinaryConf reads the binary config file .conf to find out the INSTALL_DIR and BINARY_DIR
 func BinaryConf() error {
@@ -106,11 +196,38 @@ func ZistConf() error {
 	if appConf.RPCPort == 0 {
 		return errors.New("[*] RPC port needed")
 	}
+	switch appConf.MetricsAuth {
+	case "", "token", "none":
+	case "basic":
+		if appConf.MetricsUser == "" || appConf.MetricsPassword == "" {
+			return errors.New("[*] MetricsAuth basic needs MetricsUser and MetricsPassword")
+		}
+	default:
+		return errors.New("[*] Invalid MetricsAuth " + appConf.MetricsAuth + ", use token, basic or none")
+	}
+	if (appConf.RPCCertFile == "") != (appConf.RPCKeyFile == "") {
+		return errors.New("[*] RPC TLS needs both RPCCertFile and RPCKeyFile")
+	}
+	if appConf.RPCClientCAFile != "" && appConf.RPCCertFile == "" {
+		return errors.New("[*] RPCClientCAFile needs RPCCertFile and RPCKeyFile")
+	}
+	if _, err := socketMode(); err != nil {
+		return err
+	}
+	if _, _, err := socketOwner(); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(appConf.SecretPattern); err != nil {
+		return errors.New("[*] Invalid SecretPattern: " + err.Error())
+	}
 	return nil
 }
 
-//ReadConfig reads and parses all config files
+//ReadConfig reads and parses all config files, replacing the configured jobs
 func ReadConfig() error {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	jobs = jobs[:0]
 	info, err := os.Stat(appConf.Confdir)
 	if err != nil {
 		if os.IsPermission(err) {
@@ -144,6 +261,12 @@ func ReadConfig() error {
 			continue
 		}
 	}
+	sorted, err := SortJobs(jobs)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	jobs = sorted
 	return nil
 }
 
@@ -153,6 +276,48 @@ func ParseConfig(f os.FileInfo) error {
 	if _, err := toml.DecodeFile(path.Join(appConf.Confdir, f.Name()), &job); err != nil {
 		return err
 	}
+	if _, err := ParseSignal(job.StopSignal); err != nil {
+		return err
+	}
+	if job.Args != "" && len(job.Argv) > 0 {
+		return errors.New("[*] " + job.Name + ": set either Args or Argv, not both")
+	}
+	if _, err := job.argv(); err != nil {
+		return err
+	}
+	if job.EnvFile != "" && !filepath.IsAbs(job.EnvFile) {
+		job.EnvFile = path.Join(appConf.Confdir, job.EnvFile)
+	}
+	if _, err := job.environ(); err != nil {
+		return err
+	}
+	if _, err := job.credential(); err != nil {
+		return err
+	}
+	for _, logfile := range []*string{&job.Logfile, &job.ErrLogfile} {
+		if *logfile != "" && !filepath.IsAbs(*logfile) {
+			*logfile = path.Join(job.workingDir(), *logfile)
+		}
+	}
+	if job.Syslog != nil {
+		if err := job.Syslog.Check(); err != nil {
+			return err
+		}
+	}
+	if err := job.checkLimits(); err != nil {
+		return err
+	}
+	if err := job.checkNumProcs(job.NumProcs); err != nil {
+		return err
+	}
+	for _, probe := range []*Probe{job.HealthCheck, job.ReadinessCheck} {
+		if probe == nil {
+			continue
+		}
+		if err := probe.Check(); err != nil {
+			return err
+		}
+	}
 	jobs = append(jobs, job)
 	return nil
 }
@@ -0,0 +1,96 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+//lookupUser finds a user by name or numeric uid
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+//lookupGID finds a group id by name or numeric gid
+func lookupGID(name string) (uint32, error) {
+	var g *user.Group
+	var err error
+	if _, err = strconv.Atoi(name); err == nil {
+		g, err = user.LookupGroupId(name)
+	} else {
+		g, err = user.LookupGroup(name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(gid), err
+}
+
+//runAs returns the user the job runs as, nil if the job doesn't set User
+func (job Job) runAs() (*user.User, error) {
+	if job.User == "" {
+		return nil, nil
+	}
+	u, err := lookupUser(job.User)
+	if err != nil {
+		return nil, errors.New("[*] " + job.Name + ": unknown user " + job.User)
+	}
+	return u, nil
+}
+
+//credential resolves User, Group and SupplementaryGroups to the credential the process runs with.
+//Group defaults to the user's primary group. Returns nil when the job runs as zistd's user
+func (job Job) credential() (*syscall.Credential, error) {
+	if job.User == "" && job.Group == "" && len(job.SupplementaryGroups) == 0 {
+		return nil, nil
+	}
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	u, err := job.runAs()
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+	}
+	if job.Group != "" {
+		if cred.Gid, err = lookupGID(job.Group); err != nil {
+			return nil, errors.New("[*] " + job.Name + ": unknown group " + job.Group)
+		}
+	}
+	for _, name := range job.SupplementaryGroups {
+		gid, err := lookupGID(name)
+		if err != nil {
+			return nil, errors.New("[*] " + job.Name + ": unknown group " + name)
+		}
+		cred.Groups = append(cred.Groups, gid)
+	}
+	return cred, nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//dependencyTimeout is how long a job waits for its dependencies to come up before giving up
+const dependencyTimeout = 5 * time.Minute
+
+var (
+	//cancelStart stops the jobs of the last StartJobs still waiting on their dependencies
+	cancelStart context.CancelFunc
+	//startLock serializes the waiting jobs starting their instances with StopJobs and ScaleJob
+	startLock sync.Mutex
+)
+
+//configuredJobs returns a copy of the configured jobs
+func configuredJobs() []Job {
+	jobsLock.RLock()
+	defer jobsLock.RUnlock()
+	return append([]Job(nil), jobs...)
+}
+
+//lookupJob gets a configured job by name
+func lookupJob(name string) (Job, bool) {
+	jobsLock.RLock()
+	defer jobsLock.RUnlock()
+	for _, job := range jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+//SortJobs orders jobs so that every job comes after the jobs in its DependsOn.
+//Jobs that don't depend on each other are ordered by Priority, lowest first.
+//Unknown dependencies and dependency cycles are errors
+func SortJobs(jobs []Job) ([]Job, error) {
+	byName := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		byName[job.Name] = job
+	}
+	pending := make(map[string]int, len(jobs)) //number of unstarted dependencies
+	dependents := make(map[string][]string)
+	for _, job := range jobs {
+		for _, dep := range job.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, errors.New("[*] " + job.Name + " depends on unknown job " + dep)
+			}
+			dependents[dep] = append(dependents[dep], job.Name)
+		}
+		pending[job.Name] = len(job.DependsOn)
+	}
+
+	var ready, sorted []Job
+	for _, job := range jobs {
+		if pending[job.Name] == 0 {
+			ready = append(ready, job)
+		}
+	}
+	for len(ready) > 0 {
+		sort.SliceStable(ready, func(i, j int) bool { return ready[i].Priority < ready[j].Priority })
+		job := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, job)
+		for _, name := range dependents[job.Name] {
+			if pending[name]--; pending[name] == 0 {
+				ready = append(ready, byName[name])
+			}
+		}
+	}
+	if len(sorted) < len(jobs) {
+		var cycle []string
+		for _, job := range jobs {
+			if pending[job.Name] > 0 {
+				cycle = append(cycle, job.Name)
+			}
+		}
+		return nil, errors.New("[*] Dependency cycle between " + strings.Join(cycle, ", "))
+	}
+	return sorted, nil
+}
+
+//StartJobs starts all jobs in dependency and priority order.
+//A job's instances are started once every job in its DependsOn is up
+//and the jobs with a lower Priority before it in the sorted jobs are up or failed.
+//Jobs still waiting are dropped by StopJobs
+func StartJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	startLock.Lock()
+	cancelStart = cancel
+	startLock.Unlock()
+	sorted := configuredJobs()
+	for i, job := range sorted {
+		var before []string
+		for _, prev := range sorted[:i] {
+			if prev.Priority < job.Priority {
+				before = append(before, prev.Name)
+			}
+		}
+		go func(job Job, before []string) {
+			for _, dep := range job.DependsOn {
+				if err := waitForJob(ctx, dep); err != nil {
+					log.Println("Not starting", job.Name+":", err)
+					return
+				}
+			}
+			for _, name := range before {
+				if err := waitForJob(ctx, name); err == context.Canceled {
+					log.Println("Not starting", job.Name+":", err)
+					return
+				} else if err != nil {
+					log.Println("Starting", job.Name, "anyway,", name, "is not up:", err)
+				}
+			}
+			startLock.Lock()
+			defer startLock.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			//the job may have been scaled while it waited
+			if current, ok := lookupJob(job.Name); ok {
+				job = current
+			}
+			running := jobInstances(job.Name)
+			for _, inst := range job.instances() {
+				if _, ok := running[inst.Index]; ok {
+					continue
+				}
+				if cp, err := launchProcess(inst, 0); err == nil {
+					go cp.supervise()
+				}
+			}
+		}(job, before)
+	}
+}
+
+//StopJobs stops all monitored processes, dependents before their dependencies.
+//Jobs of StartJobs still waiting on their dependencies don't start anymore
+func StopJobs() {
+	startLock.Lock()
+	if cancelStart != nil {
+		cancelStart()
+	}
+	startLock.Unlock()
+	sorted := configuredJobs()
+	for i := len(sorted) - 1; i >= 0; i-- {
+		for _, proc := range jobProcesses(sorted[i].Name) {
+			if err := proc.Kill(); err != nil {
+				log.Println(proc.Pname, "failed to exit.", err)
+			}
+		}
+	}
+	//anything left i.e processes of jobs that are no longer configured
+	for _, proc := range jobProcesses("") {
+		if err := proc.Kill(); err != nil {
+			log.Println(proc.Pname, "failed to exit.", err)
+		}
+	}
+}
+
+//jobProcesses returns the processes started from the job, all of them for an empty name
+func jobProcesses(name string) []*ChildProcess {
+	procLock.RLock()
+	defer procLock.RUnlock()
+	var procs []*ChildProcess
+	for _, proc := range activeProcesses {
+		if name == "" || proc.Conf.Template == name {
+			procs = append(procs, proc)
+		}
+	}
+	return procs
+}
+
+//jobUp checks if every instance of the job is ready
+func jobUp(job Job) (bool, error) {
+	procs := jobProcesses(job.Name)
+	if len(procs) < job.numProcs() {
+		return false, nil
+	}
+	for _, proc := range procs {
+		if !proc.Supervised() {
+			return false, errors.New("dependency " + job.Name + " is not running")
+		}
+		if proc.currentState() != StateReady {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//waitForJob blocks until the named job is up or ctx is cancelled
+func waitForJob(ctx context.Context, name string) error {
+	deadline := time.Now().Add(dependencyTimeout)
+	for time.Now().Before(deadline) {
+		if job, ok := lookupJob(name); ok {
+			up, err := jobUp(job)
+			if err != nil {
+				return err
+			}
+			if up {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return errors.New("timed out waiting for dependency " + name)
+}
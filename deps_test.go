@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortJobs(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []Job
+		want string //job names in start order, or the error they give
+	}{
+		{"empty", nil, ""},
+		{"priority", []Job{{Name: "c", Priority: 3}, {Name: "a", Priority: 1}, {Name: "b", Priority: 2}}, "a b c"},
+		{"stable", []Job{{Name: "b"}, {Name: "a"}}, "b a"},
+		{"dependency first", []Job{{Name: "web", DependsOn: []string{"db"}}, {Name: "db", Priority: 9}}, "db web"},
+		{"dependency over priority", []Job{{Name: "web", DependsOn: []string{"db"}}, {Name: "db", Priority: 9}, {Name: "cron", Priority: 5}}, "cron db web"},
+		{"chain", []Job{{Name: "c", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}, {Name: "a"}}, "a b c"},
+		{"unknown", []Job{{Name: "web", DependsOn: []string{"db"}}}, "[*] web depends on unknown job db"},
+		{"cycle", []Job{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}, {Name: "c"}}, "[*] Dependency cycle between a, b"},
+	}
+	for _, test := range tests {
+		sorted, err := SortJobs(test.jobs)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			var names []string
+			for _, job := range sorted {
+				names = append(names, job.Name)
+			}
+			got = strings.Join(names, " ")
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+//TestWaitForJobCancelled checks a job waiting on a dependency that never comes up is let go of
+func TestWaitForJobCancelled(t *testing.T) {
+	activeProcesses = make(map[int]*ChildProcess)
+	jobsLock.Lock()
+	jobs = []Job{{Name: "db", Template: "db"}}
+	jobsLock.Unlock()
+	defer func() {
+		jobsLock.Lock()
+		jobs = nil
+		jobsLock.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- waitForJob(ctx, "db") }()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waitForJob didn't return once cancelled")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//DefaultSecretPattern matches env var names whose values are masked in process status
+const DefaultSecretPattern = `(?i)(secret|passw(or)?d|token|key|credential|auth)`
+
+//environ builds the process environment.
+//zistd's own environment is inherited unless ClearEnv is set, HOME, USER and LOGNAME
+//are set for the job User, EnvFile entries are applied on top and Env overrides all
+func (job Job) environ() ([]string, error) {
+	vars := make(map[string]string)
+	if !job.ClearEnv {
+		for _, kv := range os.Environ() {
+			if i := strings.Index(kv, "="); i > 0 {
+				vars[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	u, err := job.runAs()
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		vars["HOME"] = u.HomeDir
+		vars["USER"] = u.Username
+		vars["LOGNAME"] = u.Username
+	}
+	if job.EnvFile != "" {
+		fileVars, err := ParseEnvFile(job.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	for k, v := range job.Env {
+		vars[k] = v
+	}
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+//ParseEnvFile reads a dotenv file of KEY=VALUE lines.
+//Blank lines and lines starting with # are skipped, an `export ` prefix is allowed,
+//values can be single quoted (literal) or double quoted (with \n \t \" \\ escapes)
+func ParseEnvFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return nil, errors.New("[*] " + name + ":" + strconv.Itoa(n) + ": expected KEY=VALUE")
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, errors.New("[*] " + name + ":" + strconv.Itoa(n) + ": " + err.Error())
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+//parseEnvValue unquotes a dotenv value, values end at an inline ` #` comment.
+//Anything else after a closing quote is an error
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch value[0] {
+	case '\'':
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated quote")
+		}
+		return value[1 : end+1], afterQuote(value[end+2:])
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			if c == '"' {
+				return b.String(), afterQuote(value[i+1:])
+			}
+			if c == '\\' && i+1 < len(value) {
+				i++
+				switch value[i] {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				default:
+					c = value[i]
+				}
+			}
+			b.WriteByte(c)
+		}
+		return "", errors.New("unterminated quote")
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+//afterQuote checks the rest of a value after its closing quote is empty or a ` #` comment
+func afterQuote(rest string) error {
+	trimmed := strings.TrimLeft(rest, " \t")
+	if trimmed == "" || trimmed[0] == '#' && len(trimmed) < len(rest) {
+		return nil
+	}
+	return errors.New("unexpected " + strconv.Quote(rest) + " after the closing quote")
+}
+
+//MaskEnv maps the environment to its values with those of secret looking names masked
+func MaskEnv(env []string) map[string]string {
+	pattern := DefaultSecretPattern
+	if appConf.SecretPattern != "" {
+		pattern = appConf.SecretPattern
+	}
+	secret := regexp.MustCompile(pattern)
+	masked := make(map[string]string, len(env))
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		if secret.MatchString(kv[:i]) {
+			masked[kv[:i]] = "********"
+			continue
+		}
+		masked[kv[:i]] = kv[i+1:]
+	}
+	return masked
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zist-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, ".env")
+	file := `# comment
+
+A=plain
+export B = spaced
+C='q#r'
+D="line\nnext \"quoted\" \\ end"
+E=value # comment
+F='single' # comment
+G="double"	# comment
+H=
+I=a#b
+`
+	if err := ioutil.WriteFile(name, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := ParseEnvFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"A": "plain",
+		"B": "spaced",
+		"C": "q#r",
+		"D": "line\nnext \"quoted\" \\ end",
+		"E": "value",
+		"F": "single",
+		"G": "double",
+		"H": "",
+		"I": "a#b",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("got %q, want %q", vars, want)
+	}
+
+	for _, line := range []string{
+		"NOVALUE",
+		"=value",
+		"A='unterminated",
+		`A="unterminated`,
+		"A='q#r' trailing",
+		`A="v"trailing`,
+		"A='v'#no space",
+	} {
+		if err := ioutil.WriteFile(name, []byte("OK=1\n"+line+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseEnvFile(name)
+		if err == nil {
+			t.Errorf("%s: no error", line)
+		} else if !strings.Contains(err.Error(), ".env:2:") {
+			t.Errorf("%s: error %q doesn't name line 2", line, err)
+		}
+	}
+}
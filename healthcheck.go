@@ -0,0 +1,267 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ziscky/zist/zistrpc"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	//HealthUnknown is the health of a process that hasn't been probed yet
+	HealthUnknown = "unknown"
+	//HealthHealthy is the health of a process whose last probe succeeded
+	HealthHealthy = "healthy"
+	//HealthUnhealthy is the health of a process that failed FailureThreshold probes in a row
+	HealthUnhealthy = "unhealthy"
+)
+
+//maxProbeOutput caps the probe output kept for status
+const maxProbeOutput = 256
+
+//Probe configures a health check of type http, tcp or exec
+type Probe struct {
+	Type string
+	//URL is requested by http probes, ExpectedStatus defaults to 200
+	URL            string
+	ExpectedStatus int
+	//Address is dialed by tcp probes i.e localhost:8080
+	Address string
+	//Command is run by exec probes and must exit 0, split with shell word rules
+	Command string
+	//InitialDelay, Interval and Timeout are in seconds
+	InitialDelay int
+	Interval     int
+	Timeout      int
+	//FailureThreshold is the number of failed probes in a row before the process is restarted
+	FailureThreshold int
+}
+
+//Check validates the probe configuration
+func (p *Probe) Check() error {
+	switch p.Type {
+	case "http":
+		if p.URL == "" {
+			return errors.New("[*] http health check needs a URL")
+		}
+	case "tcp":
+		if p.Address == "" {
+			return errors.New("[*] tcp health check needs an Address")
+		}
+	case "exec":
+		args, err := SplitArgs(p.Command)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errors.New("[*] exec health check needs a Command")
+		}
+	default:
+		return errors.New("[*] Unknown health check type " + p.Type + ", use http, tcp or exec")
+	}
+	return nil
+}
+
+func (p *Probe) interval() time.Duration {
+	if p.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(p.Interval) * time.Second
+}
+
+func (p *Probe) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+func (p *Probe) failureThreshold() int {
+	if p.FailureThreshold <= 0 {
+		return 3
+	}
+	return p.FailureThreshold
+}
+
+//Run probes the process once
+func (p *Probe) Run(cp *ChildProcess) zistrpc.ProbeResult {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	var output string
+	var err error
+	switch p.Type {
+	case "http":
+		output, err = p.runHTTP(ctx)
+	case "tcp":
+		output, err = p.runTCP(ctx)
+	case "exec":
+		output, err = p.runExec(ctx, cp)
+	}
+	if err != nil {
+		output = strings.TrimSpace(output + " " + err.Error())
+	}
+	if len(output) > maxProbeOutput {
+		output = output[:maxProbeOutput]
+	}
+	return zistrpc.ProbeResult{
+		OK:       err == nil,
+		Output:   output,
+		Time:     start,
+		Duration: time.Since(start).String(),
+	}
+}
+
+func (p *Probe) runHTTP(ctx context.Context) (string, error) {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	expected := p.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	if resp.StatusCode != expected {
+		return resp.Status, errors.New("expected status " + strconv.Itoa(expected))
+	}
+	return resp.Status, nil
+}
+
+func (p *Probe) runTCP(ctx context.Context) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Address)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return "connected to " + p.Address, nil
+}
+
+//runExec runs the probe command as the job User/Group with the job environment
+func (p *Probe) runExec(ctx context.Context, cp *ChildProcess) (string, error) {
+	args, _ := SplitArgs(p.Command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	env, err := cp.Conf.environ()
+	if err != nil {
+		return "", err
+	}
+	cred, err := cp.Conf.credential()
+	if err != nil {
+		return "", err
+	}
+	cmd.Env = env
+	cmd.Dir = cp.Conf.workingDir()
+	if cred != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+//probeLoop runs the probe every Interval after InitialDelay until exited is closed
+//or handle returns false
+func (cp *ChildProcess) probeLoop(p *Probe, exited chan struct{}, handle func(zistrpc.ProbeResult) bool) {
+	select {
+	case <-time.After(time.Duration(p.InitialDelay) * time.Second):
+	case <-exited:
+		return
+	}
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	for {
+		if !handle(p.Run(cp)) {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-exited:
+			return
+		}
+	}
+}
+
+//monitorHealth runs the liveness check of the process.
+//The process is restarted after FailureThreshold failed probes in a row
+func (cp *ChildProcess) monitorHealth(p *Probe, exited chan struct{}) {
+	cp.probeLoop(p, exited, func(result zistrpc.ProbeResult) bool {
+		cp.stateLock.Lock()
+		cp.LastProbe = &result
+		if result.OK {
+			cp.Health = HealthHealthy
+			cp.HealthFailures = 0
+			cp.stateLock.Unlock()
+			return true
+		}
+		cp.HealthFailures++
+		cp.HealthFailuresTotal++
+		failures := cp.HealthFailures
+		if failures >= p.failureThreshold() {
+			cp.Health = HealthUnhealthy
+		}
+		cp.stateLock.Unlock()
+		if failures < p.failureThreshold() {
+			return true
+		}
+		cp.transition(StateUnhealthy, StateStarting, StateReady)
+		cp.Recycle("failed " + strconv.Itoa(failures) + " health checks: " + result.Output)
+		return false
+	})
+}
+
+//monitorReadiness marks the process ready once its readiness check passes,
+//without a readiness check the process is ready after staying up for StartSecs.
+//A ready process failing FailureThreshold readiness probes in a row is unhealthy until it passes again
+func (cp *ChildProcess) monitorReadiness(job Job, exited chan struct{}) {
+	p := job.ReadinessCheck
+	if p == nil {
+		select {
+		case <-time.After(job.startSecs()):
+			cp.transition(StateReady, StateStarting)
+		case <-exited:
+		}
+		return
+	}
+	failures := 0
+	cp.probeLoop(p, exited, func(result zistrpc.ProbeResult) bool {
+		cp.stateLock.Lock()
+		cp.LastReadiness = &result
+		cp.stateLock.Unlock()
+		if result.OK {
+			failures = 0
+			cp.transition(StateReady, StateStarting, StateUnhealthy)
+			return true
+		}
+		if failures++; failures >= p.failureThreshold() {
+			cp.transition(StateUnhealthy, StateReady)
+		}
+		return true
+	})
+}
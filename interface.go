@@ -19,36 +19,54 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
-	"log"
+	"github.com/ziscky/zist/zistrpc"
 	"os"
+	"strconv"
 	"time"
 )
 
-//RPC handlers working as an interface to the cli tool
+//RPC handlers working as an interface to the cli tool.
+//Arguments, replies and errors are the zistrpc types shared with zistcl
 
 //Communicator handles all remote communication with zistd following the gorpc structure
 type Communicator struct{}
 
-//VerifyToken verifies the given token from zistcl
+//findProcess gets a monitored process by name
+func findProcess(name string) (*ChildProcess, error) {
+	procLock.RLock()
+	defer procLock.RUnlock()
+	for _, proc := range activeProcesses {
+		if proc.Pname == name {
+			return proc, nil
+		}
+	}
+	return nil, zistrpc.NotFound(name)
+}
+
+//failed wraps an error of a call that went wrong in zistd
+func failed(err error) error {
+	if _, ok := err.(*zistrpc.Error); ok {
+		return err
+	}
+	return &zistrpc.Error{Code: zistrpc.CodeFailed, Message: err.Error()}
+}
+
+//VerifyToken verifies the given token from zistcl.
+//Connections are already authenticated by RPCAuth, this only checks a token
 func (comm *Communicator) VerifyToken(token string, valid *bool) error {
 	*valid = appConf.Token == token
 	return nil
 }
 
-//Kill kills zistd
-//i=0 kill with all monitored processes
-//i=1 detach all processes then kill
-func (comm *Communicator) Kill(i int, msg *string) error {
-	for _, proc := range activeProcesses {
-		if i == 0 {
-			if err := proc.Kill(); err != nil {
-				*msg += proc.Pname + " failed to exit." + err.Error()
-			}
-			continue
-		}
+//Kill kills zistd with all monitored processes, or detaches them first
+func (comm *Communicator) Kill(args zistrpc.KillArgs, reply *zistrpc.MessageReply) error {
+	if !args.Detach {
+		StopJobs()
+		os.Exit(0)
+	}
+	for _, proc := range jobProcesses("") {
 		if err := proc.Detach(); err != nil {
-			*msg += proc.Pname + " failed to detach." + err.Error()
+			reply.Message += proc.Pname + " failed to detach." + err.Error()
 		}
 	}
 	os.Exit(0)
@@ -57,24 +75,17 @@ func (comm *Communicator) Kill(i int, msg *string) error {
 
 //Reload reloads the monitored process configs
 //Also reload zistd config??
-func (comm *Communicator) Reload(_ int, msg *string) error {
-	for _, proc := range activeProcesses {
-		if err := proc.Kill(); err != nil {
-			log.Println(err)
-			continue
-		}
+func (comm *Communicator) Reload(_ int, reply *zistrpc.MessageReply) error {
+	StopJobs()
+	for _, proc := range jobProcesses("") {
 		RemoveProcess(proc)
 	}
-	jobs = jobs[:0]
 	if err := ReadConfig(); err != nil {
-		*msg = err.Error()
-		return nil
+		return zistrpc.Errorf(zistrpc.CodeInvalid, "%s", err)
 	}
 
-	for _, job := range jobs {
-		go RegisterProcess(job, 0)
-	}
-	*msg = "Succesfully reloaded configs"
+	StartJobs()
+	reply.Message = "Succesfully reloaded configs"
 	return nil
 }
 
@@ -85,174 +96,176 @@ func (comm *Communicator) Status(_ int, ack *bool) error {
 }
 
 //ProcessStatus gets the overall status of a process by name as defined
-//in the proc config file
-func (comm *Communicator) ProcessStatus(name string, msg *string) error {
-	procLock.Lock()
-	defer procLock.Unlock()
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			payload := map[string]interface{}{
-				"pid":         proc.PID,
-				"name":        proc.Pname,
-				"path":        proc.PPath,
-				"args":        proc.Args,
-				"numrestarts": proc.RestartCount,
-				"timestarted": proc.Timestamp.String(),
-				"timealive":   time.Since(proc.Timestamp).String(),
-				"isalive":     proc.IsAlive,
-			}
-			payloadJSON, err := json.Marshal(payload)
-			if err != nil {
-				log.Println(err)
-				return err
-			}
-			*msg = string(payloadJSON)
-			return nil
-		}
+//in the proc config file, with its masked environment
+func (comm *Communicator) ProcessStatus(args zistrpc.ProcessArgs, reply *zistrpc.ProcessInfo) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
 	}
-	*msg = "No such process"
+	*reply = proc.Info()
+	reply.Env = MaskEnv(proc.Environ())
 	return nil
 }
 
 //ProcessStop stops the requested process by name
-func (comm *Communicator) ProcessStop(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			if err := proc.Kill(); err != nil {
-				*msg = err.Error()
-				return err
-			}
-			proc.IsAlive = false
-			*msg = "Succesfully stopped"
-			return nil
-		}
+func (comm *Communicator) ProcessStop(args zistrpc.ProcessArgs, reply *zistrpc.MessageReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
 	}
-	*msg = "No such process"
+	if err := proc.Kill(); err != nil {
+		return failed(err)
+	}
+	reply.Message = "Succesfully stopped"
 	return nil
 }
 
 //ProcessDetach detaches the child process to become it's own process, losing state ofcourse
-func (comm *Communicator) ProcessDetach(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			if err := proc.Detach(); err != nil {
-				*msg = err.Error()
-				return err
-			}
-			*msg = "Succesfully detached"
-			return nil
-		}
+func (comm *Communicator) ProcessDetach(args zistrpc.ProcessArgs, reply *zistrpc.MessageReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	if err := proc.Detach(); err != nil {
+		return failed(err)
 	}
-	*msg = "No such process"
+	reply.Message = "Succesfully detached"
 	return nil
 }
 
 //ProcessRestart restarts the process by name
-func (comm *Communicator) ProcessRestart(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			if err := proc.Kill(); err != nil {
-				*msg = err.Error()
-				return err
-			}
-			if err := startProcess(proc, proc.PID, proc.RestartCount+1); err != nil {
-				*msg = err.Error()
-				return err
-			}
-			*msg = "Succesfully restarted"
-			return nil
-		}
+func (comm *Communicator) ProcessRestart(args zistrpc.ProcessArgs, reply *zistrpc.MessageReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	if err := restartProcess(proc); err != nil {
+		return failed(err)
 	}
-	*msg = "No such process"
+	reply.Message = "Succesfully restarted"
 	return nil
 }
 
 //ProcessStart starts a monitored process by name
-func (comm *Communicator) ProcessStart(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			if proc.IsAlive {
-				*msg = "Process already running"
-				return nil
-			}
-			if err := startProcess(proc, proc.PID, 0); err != nil {
-				*msg = err.Error()
-				return err
-			}
-			*msg = "Succesfully started"
-			return nil
-		}
+func (comm *Communicator) ProcessStart(args zistrpc.ProcessArgs, reply *zistrpc.MessageReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	if err := startStopped(proc); err != nil {
+		return failed(err)
 	}
-	*msg = "No such process"
+	reply.Message = "Succesfully started"
 	return nil
 }
 
-//ProcessStats gets the monitored  process stats by name
-func (comm *Communicator) ProcessStats(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			stats, err := proc.Stats()
-			if err != nil {
-				*msg = err.Error()
-				return err
-			}
-			encodedstats, _ := json.Marshal(stats)
-			*msg = string(encodedstats)
-			return nil
-		}
+//ProcessLogRotate rotates the log files of the process by name
+func (comm *Communicator) ProcessLogRotate(args zistrpc.ProcessArgs, reply *zistrpc.MessageReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	if err := proc.RotateLogs(); err != nil {
+		return failed(err)
 	}
-	*msg = "No such process"
+	reply.Message = "Succesfully rotated logs"
 	return nil
 }
 
-//ProcessStdErr gets the process stderr output by name
-func (comm *Communicator) ProcessStdErr(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			stats := proc.GetErrors()
+//ProcessScale changes the number of instances of the job the named process belongs to
+func (comm *Communicator) ProcessScale(args zistrpc.ScaleArgs, reply *zistrpc.MessageReply) error {
+	if err := ScaleJob(args.Name, args.N); err != nil {
+		return failed(err)
+	}
+	reply.Message = "Succesfully scaled to " + strconv.Itoa(args.N)
+	return nil
+}
 
-			encodedstats, _ := json.Marshal(stats)
-			*msg = string(encodedstats)
-			return nil
-		}
+//ProcessStats gets the monitored process tree stats by name
+func (comm *Communicator) ProcessStats(args zistrpc.ProcessArgs, reply *zistrpc.ProcStats) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	if !proc.Running() {
+		return zistrpc.Errorf(zistrpc.CodeConflict, "%s is %s", proc.Pname, proc.currentState())
+	}
+	stats, err := proc.Stats()
+	if err != nil {
+		return failed(err)
+	}
+	*reply = *stats
+	return nil
+}
+
+//ProcessStatsHistory gets the sampled resource usage of the process by name with its min, avg and max
+func (comm *Communicator) ProcessStatsHistory(args zistrpc.HistoryArgs, reply *zistrpc.MetricsReport) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
 	}
-	*msg = "No such process"
+	*reply = proc.StatsHistory(args.Since)
+	return nil
+}
+
+//ProcessStdErr gets the process stderr output by name
+func (comm *Communicator) ProcessStdErr(args zistrpc.LogArgs, reply *zistrpc.LogReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	reply.Lines = proc.GetErrors(args.Since)
 	return nil
 }
 
 //ProcessStdOut gets the process stdout output by name
-func (comm *Communicator) ProcessStdOut(name string, msg *string) error {
-	for _, proc := range activeProcesses {
-		if proc.Pname == name {
-			stats := proc.GetErrors()
+func (comm *Communicator) ProcessStdOut(args zistrpc.LogArgs, reply *zistrpc.LogReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	reply.Lines = proc.GetOutput(args.Since)
+	return nil
+}
 
-			encodedstats, _ := json.Marshal(stats)
-			*msg = string(encodedstats)
-			return nil
-		}
+//ProcessTail streams the process output to zistcl, which calls it again with the
+//last sequence number it got to follow the output
+func (comm *Communicator) ProcessTail(args zistrpc.TailArgs, reply *zistrpc.LogReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	buf := proc.Output
+	if args.Stderr {
+		buf = proc.Errors
+	}
+	if args.Lines > 0 && args.Since == 0 {
+		reply.Lines = buf.Last(args.Lines)
+	} else {
+		reply.Lines = buf.Wait(args.Since, time.Duration(args.Wait)*time.Second, nil)
 	}
-	*msg = "No such process"
+	return nil
+}
+
+//ProcessLogs gets the merged, time ordered stdout and stderr of the process by name
+func (comm *Communicator) ProcessLogs(args zistrpc.LogsArgs, reply *zistrpc.LogReply) error {
+	proc, err := findProcess(args.Name)
+	if err != nil {
+		return err
+	}
+	lines, err := proc.Logs(LogFilter{From: args.From, To: args.To, Match: args.Match, Stdout: true, Stderr: true})
+	if err != nil {
+		return zistrpc.Errorf(zistrpc.CodeInvalid, "%s", err)
+	}
+	reply.Lines = lines
 	return nil
 }
 
 //All gets all monitored process info
-func (comm *Communicator) All(_ int, msg *string) error {
-	var payloads []map[string]interface{}
-	for _, proc := range activeProcesses {
-		payload := map[string]interface{}{
-			"pid":         proc.PID,
-			"name":        proc.Pname,
-			"path":        proc.PPath,
-			"args":        proc.Args,
-			"numrestarts": proc.RestartCount,
-			"timestarted": proc.Timestamp.String(),
-			"timealive":   time.Since(proc.Timestamp).String(),
-			"isalive":     proc.IsAlive,
-		}
-		payloads = append(payloads, payload)
+func (comm *Communicator) All(_ int, reply *[]zistrpc.ProcessInfo) error {
+	for _, proc := range jobProcesses("") {
+		*reply = append(*reply, proc.Info())
 	}
-	payloadJSON, _ := json.Marshal(payloads)
-	*msg = string(payloadJSON)
 	return nil
 }
 
@@ -260,8 +273,7 @@ func (comm *Communicator) All(_ int, msg *string) error {
 func (comm *Communicator) ReadLog(_ int, msg *string) error {
 	f, err := os.OpenFile("error.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		*msg = err.Error()
-		return err
+		return failed(err)
 	}
 	defer f.Close()
 	scanner := bufio.NewScanner(f)
@@ -273,18 +285,12 @@ func (comm *Communicator) ReadLog(_ int, msg *string) error {
 }
 
 //ClearLog clears zistd output log
-func (comm *Communicator) ClearLog(_ int, msg *string) error {
-	f, err := os.OpenFile("error.log", os.O_RDWR|os.O_CREATE, 0666)
+func (comm *Communicator) ClearLog(_ int, reply *zistrpc.MessageReply) error {
+	f, err := os.OpenFile("error.log", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		*msg = err.Error()
-		return err
+		return failed(err)
 	}
 	defer f.Close()
-	_, err1 := f.WriteString("")
-	if err1 != nil {
-		*msg = err1.Error()
-		return err
-	}
-	*msg = "Succesfully cleared zistd log"
+	reply.Message = "Succesfully cleared zistd log"
 	return nil
 }
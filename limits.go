@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	//DefaultMaxCPUSecs is how long the cpu usage must stay above MaxCPU before a restart
+	DefaultMaxCPUSecs = 60
+	//limitInterval is how often the limits are checked
+	limitInterval = 5 * time.Second
+)
+
+//hasLimits checks if the job restarts on memory or cpu usage
+func (job Job) hasLimits() bool {
+	return job.MaxMemory > 0 || job.MaxCPU > 0
+}
+
+//checkLimits validates the memory and cpu limits
+func (job Job) checkLimits() error {
+	if job.MaxMemory < 0 || job.MaxCPU < 0 || job.MaxCPUSecs < 0 {
+		return errors.New("[*] " + job.Name + ": MaxMemory, MaxCPU and MaxCPUSecs can't be negative")
+	}
+	return nil
+}
+
+func (job Job) maxCPUSecs() time.Duration {
+	if job.MaxCPUSecs == 0 {
+		return DefaultMaxCPUSecs * time.Second
+	}
+	return time.Duration(job.MaxCPUSecs) * time.Second
+}
+
+//monitorLimits restarts the process when its tree uses more than MaxMemory,
+//or more than MaxCPU for MaxCPUSecs
+func (cp *ChildProcess) monitorLimits(job Job, exited chan struct{}) {
+	interval := limitInterval
+	if window := job.maxCPUSecs(); window < interval {
+		interval = window
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var overSince time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-exited:
+			return
+		}
+		stats, err := cp.Stats()
+		if err != nil {
+			continue
+		}
+		total := stats.Total
+		if job.MaxMemory > 0 && total.RSS > uint64(job.MaxMemory)<<20 {
+			cp.Recycle(fmt.Sprintf("memory %.1fMB over MaxMemory %dMB", float64(total.RSS)/(1<<20), job.MaxMemory))
+			return
+		}
+		if job.MaxCPU <= 0 {
+			continue
+		}
+		if total.CPUPercent <= job.MaxCPU {
+			overSince = time.Time{}
+			continue
+		}
+		if overSince.IsZero() {
+			overSince = time.Now()
+			log.Printf("%s cpu %.1f%% over MaxCPU %.1f%%, restarting if it stays over for %s", cp.Pname, total.CPUPercent, job.MaxCPU, job.maxCPUSecs())
+		}
+		if time.Since(overSince) >= job.maxCPUSecs() {
+			cp.Recycle(fmt.Sprintf("cpu %.1f%% over MaxCPU %.1f%% for %s", total.CPUPercent, job.MaxCPU, time.Since(overSince).Round(time.Second)))
+			return
+		}
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//DefaultLogBackups is the number of rotated log files kept when LogBackups isn't set
+const DefaultLogBackups = 5
+
+//RotatingFile is a log file rotated by size and by age.
+//Rotated files are renamed to name.1, name.2 ... up to Backups, optionally gzipped
+type RotatingFile struct {
+	Name     string
+	MaxSize  int64         //bytes, 0 disables size rotation
+	MaxAge   time.Duration //0 disables age rotation
+	Backups  int
+	Compress bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time //when the file was started, MaxAge is counted from it
+	//gzipping is the compression of the last rotated file running in the background
+	gzipping sync.WaitGroup
+}
+
+//OpenRotatingFile opens the log file for appending, creating it if needed
+func OpenRotatingFile(name string, maxSize int64, maxAge time.Duration, backups int, compress bool) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Name:     name,
+		MaxSize:  maxSize,
+		MaxAge:   maxAge,
+		Backups:  backups,
+		Compress: compress,
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	if rf.size > 0 {
+		rf.opened = rf.started(info)
+	}
+	return nil
+}
+
+//started guesses when an existing file was started, Linux doesn't report creation times.
+//The file was started when the last one was rotated, which is when the last backup was written.
+//Without backups it's the file's modification time
+func (rf *RotatingFile) started(info os.FileInfo) time.Time {
+	for _, name := range []string{rf.backup(1), rf.backup(1) + ".gz"} {
+		if backup, err := os.Stat(name); err == nil && backup.ModTime().Before(info.ModTime()) {
+			return backup.ModTime()
+		}
+	}
+	return info.ModTime()
+}
+
+//Write appends p to the file, rotating first when p would take it over MaxSize or it's older than MaxAge
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, errors.New("[*] " + rf.Name + " is closed")
+	}
+	if (rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize) ||
+		(rf.MaxAge > 0 && time.Since(rf.opened) > rf.MaxAge) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+//Rotate moves the current file aside and starts a new one
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return errors.New("[*] " + rf.Name + " is closed")
+	}
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	backups := rf.Backups
+	if backups <= 0 {
+		backups = DefaultLogBackups
+	}
+	ext := ""
+	if rf.Compress {
+		ext = ".gz"
+	}
+	//the backups can only move once the last one is compressed
+	rf.gzipping.Wait()
+	os.Remove(rf.backup(backups) + ext)
+	for i := backups - 1; i > 0; i-- {
+		os.Rename(rf.backup(i)+ext, rf.backup(i+1)+ext)
+	}
+	err := os.Rename(rf.Name, rf.backup(1))
+	if err == nil && rf.Compress {
+		//compress in the background so writes to the new file don't wait for it
+		rf.gzipping.Add(1)
+		go func(name string) {
+			defer rf.gzipping.Done()
+			if err := gzipFile(name); err != nil {
+				log.Println("Compressing", name, "failed:", err)
+			}
+		}(rf.backup(1))
+	}
+	//keep logging to a fresh file even if moving the old one failed
+	if openErr := rf.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (rf *RotatingFile) backup(i int) string {
+	return rf.Name + "." + strconv.Itoa(i)
+}
+
+//Close closes the file, later writes fail
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+//gzipFile compresses name to name.gz and removes name
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+//openLogs opens the job's stdout and stderr log files.
+//stderr shares the Logfile unless ErrLogfile is set, either is nil when not configured
+func (job Job) openLogs() (*RotatingFile, *RotatingFile, error) {
+	open := func(name string) (*RotatingFile, error) {
+		return OpenRotatingFile(name, int64(job.LogMaxSize)*1024*1024,
+			time.Duration(job.LogMaxAge)*time.Hour, job.LogBackups, job.LogCompress)
+	}
+	var outLog, errLog *RotatingFile
+	var err error
+	if job.Logfile != "" {
+		if outLog, err = open(job.Logfile); err != nil {
+			return nil, nil, err
+		}
+		errLog = outLog
+	}
+	if job.ErrLogfile != "" {
+		if errLog, err = open(job.ErrLogfile); err != nil {
+			if outLog != nil {
+				outLog.Close()
+			}
+			return nil, nil, err
+		}
+	}
+	return outLog, errLog, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"encoding/json"
+	"github.com/ziscky/zist/zistrpc"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//LogFilter selects lines of the unified process log.
+//Zero times leave the range open, an empty Match matches every line
+type LogFilter struct {
+	From   time.Time
+	To     time.Time
+	Match  string
+	Stdout bool
+	Stderr bool
+}
+
+//Logs merges the captured stdout and stderr of the process into one time ordered log
+func (cp *ChildProcess) Logs(filter LogFilter) ([]zistrpc.LogLine, error) {
+	var match *regexp.Regexp
+	if filter.Match != "" {
+		var err error
+		if match, err = regexp.Compile(filter.Match); err != nil {
+			return nil, err
+		}
+	}
+	var merged []zistrpc.LogLine
+	if filter.Stdout {
+		merged = append(merged, cp.Output.Lines()...)
+	}
+	if filter.Stderr {
+		merged = append(merged, cp.Errors.Lines()...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+
+	lines := []zistrpc.LogLine{}
+	for _, line := range merged {
+		if !filter.From.IsZero() && line.Time.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && line.Time.After(filter.To) {
+			continue
+		}
+		if match != nil && !match.MatchString(line.Text) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+//JSONLines encodes the lines as JSON Lines, one object per line
+func JSONLines(lines []zistrpc.LogLine) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, line := range lines {
+		enc.Encode(line)
+	}
+	return b.String()
+}
+
+//ParseLogTime reads a log filter time, either RFC3339 or a duration meaning that long ago i.e 15m
+func ParseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
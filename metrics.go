@@ -0,0 +1,159 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"github.com/ziscky/zist/zistrpc"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultStatsInterval is how often processes are sampled for the stats history
+	DefaultStatsInterval = 10 * time.Second
+	//DefaultStatsHistory is how long the stats history is kept
+	DefaultStatsHistory = 24 * time.Hour
+	//fullResolution is how long samples are kept at StatsInterval before they are merged into minutes
+	fullResolution = time.Hour
+	//downsampled is the resolution of samples older than fullResolution
+	downsampled = time.Minute
+)
+
+func point(v float64) zistrpc.Range {
+	return zistrpc.Range{Min: v, Avg: v, Max: v}
+}
+
+//newMetricPoint samples the totals of a process tree
+func newMetricPoint(stats *zistrpc.ProcStats) zistrpc.MetricPoint {
+	total := stats.Total
+	return zistrpc.MetricPoint{
+		Time:    stats.Time,
+		Samples: 1,
+		CPU:     point(total.CPUPercent),
+		RSS:     point(float64(total.RSS)),
+		FDs:     point(float64(total.FDs)),
+		Threads: point(float64(total.Threads)),
+	}
+}
+
+//MetricsHistory keeps the samples of a process for the last fullResolution and
+//downsamples older ones to a point per minute, dropping them after retention
+type MetricsHistory struct {
+	retention time.Duration
+	recent    []zistrpc.MetricPoint
+	older     []zistrpc.MetricPoint
+	lock      sync.RWMutex
+}
+
+//NewMetricsHistory creates a history kept for retention
+func NewMetricsHistory(retention time.Duration) *MetricsHistory {
+	if retention <= 0 {
+		retention = DefaultStatsHistory
+	}
+	return &MetricsHistory{retention: retention}
+}
+
+//Add records a sample, downsampling and dropping the ones that aged
+func (mh *MetricsHistory) Add(mp zistrpc.MetricPoint) {
+	mh.lock.Lock()
+	defer mh.lock.Unlock()
+	mh.recent = append(mh.recent, mp)
+	for len(mh.recent) > 0 && mp.Time.Sub(mh.recent[0].Time) > fullResolution {
+		old := mh.recent[0]
+		mh.recent = mh.recent[1:]
+		bucket := old.Time.Truncate(downsampled)
+		if n := len(mh.older); n > 0 && mh.older[n-1].Time.Equal(bucket) {
+			mh.older[n-1].Merge(old)
+			continue
+		}
+		old.Time = bucket
+		mh.older = append(mh.older, old)
+	}
+	for len(mh.older) > 0 && mp.Time.Sub(mh.older[0].Time) > mh.retention {
+		mh.older = mh.older[1:]
+	}
+}
+
+//Since returns the samples taken after since, oldest first
+func (mh *MetricsHistory) Since(since time.Time) []zistrpc.MetricPoint {
+	mh.lock.RLock()
+	defer mh.lock.RUnlock()
+	points := []zistrpc.MetricPoint{}
+	for _, list := range [][]zistrpc.MetricPoint{mh.older, mh.recent} {
+		for _, mp := range list {
+			if !mp.Time.Before(since) {
+				points = append(points, mp)
+			}
+		}
+	}
+	return points
+}
+
+//StatsHistory gets the stats history of the process after since
+func (cp *ChildProcess) StatsHistory(since time.Time) zistrpc.MetricsReport {
+	points := cp.History.Since(since)
+	return zistrpc.MetricsReport{Since: since, Interval: statsInterval().String(), Summary: zistrpc.Summarize(points), Points: points}
+}
+
+//statsInterval is how often processes are sampled, sampling is off when StatsInterval is negative
+func statsInterval() time.Duration {
+	if appConf.StatsInterval == 0 {
+		return DefaultStatsInterval
+	}
+	return time.Duration(appConf.StatsInterval) * time.Second
+}
+
+//statsHistory is how long the stats history is kept
+func statsHistory() time.Duration {
+	if appConf.StatsHistory <= 0 {
+		return DefaultStatsHistory
+	}
+	return time.Duration(appConf.StatsHistory) * time.Hour
+}
+
+//sampleMetrics adds a sample of every running process to its history each statsInterval
+func sampleMetrics() {
+	interval := statsInterval()
+	if interval < 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		for _, cp := range jobProcesses("") {
+			if cp.Running() {
+				cp.sample()
+			}
+		}
+	}
+}
+
+//sample reads the process tree and records it in the history, the cpu percentage is
+//measured from the previous sample
+func (cp *ChildProcess) sample() {
+	stats, err := ReadProcTree(cp.PID)
+	if err != nil {
+		log.Println(cp.Pname, "stats:", err)
+		return
+	}
+	if cp.lastSample != nil && cp.lastSample.PID == stats.PID {
+		stats.TreeCPUPercentSince(cp.lastSample)
+	}
+	stats.SumTree()
+	cp.lastSample = stats
+	cp.History.Add(newMetricPoint(stats))
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ziscky/zist/zistrpc"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//IndexEnv is the environment variable holding the instance index of a process
+const IndexEnv = "ZIST_INDEX"
+
+//indexTemplate matches the instance index in a job name i.e worker-%(index)d or worker-%(index)02d
+var indexTemplate = regexp.MustCompile(`%\(index\)(0?[0-9]*)d`)
+
+//numProcs returns the number of instances the job runs
+func (job Job) numProcs() int {
+	if job.NumProcs <= 0 {
+		return 1
+	}
+	return job.NumProcs
+}
+
+//checkNumProcs makes sure every instance of the job gets its own name
+func (job Job) checkNumProcs(n int) error {
+	if n < 0 {
+		return errors.New("[*] " + job.Name + ": NumProcs can't be negative")
+	}
+	if n > 1 && !indexTemplate.MatchString(job.Name) {
+		return errors.New("[*] " + job.Name + ": running more than one instance needs %(index)d in Name")
+	}
+	for _, logfile := range []string{job.Logfile, job.ErrLogfile} {
+		if n > 1 && logfile != "" && !indexTemplate.MatchString(logfile) {
+			return errors.New("[*] " + job.Name + ": running more than one instance needs %(index)d in " + logfile)
+		}
+	}
+	return nil
+}
+
+//instance returns the job for the instance at index with the name template expanded
+func (job Job) instance(index int) Job {
+	inst := job
+	inst.Template = job.Name
+	inst.Index = index
+	inst.Name = expandIndex(job.Name, index)
+	inst.Logfile = expandIndex(job.Logfile, index)
+	inst.ErrLogfile = expandIndex(job.ErrLogfile, index)
+	inst.Env = map[string]string{IndexEnv: strconv.Itoa(index)}
+	for k, v := range job.Env {
+		inst.Env[k] = v
+	}
+	return inst
+}
+
+//expandIndex replaces the %(index)d template in s with index
+func expandIndex(s string, index int) string {
+	return indexTemplate.ReplaceAllStringFunc(s, func(m string) string {
+		return fmt.Sprintf("%"+indexTemplate.FindStringSubmatch(m)[1]+"d", index)
+	})
+}
+
+//instances returns a job for each of the NumProcs instances
+func (job Job) instances() []Job {
+	var insts []Job
+	for i := 0; i < job.numProcs(); i++ {
+		insts = append(insts, job.instance(i))
+	}
+	return insts
+}
+
+//findJob finds a job by its Name template or by the name of one of its instances
+func findJob(name string) (Job, bool) {
+	if job, ok := lookupJob(name); ok {
+		return job, true
+	}
+	if proc, err := findProcess(name); err == nil {
+		return lookupJob(proc.Conf.Template)
+	}
+	return Job{}, false
+}
+
+//setNumProcs stores the number of instances of the named job
+func setNumProcs(name string, n int) {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	for i := range jobs {
+		if jobs[i].Name == name {
+			jobs[i].NumProcs = n
+		}
+	}
+}
+
+//jobInstances returns the processes of the job by instance index
+func jobInstances(name string) map[int]*ChildProcess {
+	running := make(map[int]*ChildProcess)
+	for _, proc := range jobProcesses(name) {
+		running[proc.Conf.Index] = proc
+	}
+	return running
+}
+
+//ScaleJob changes the number of running instances of a job.
+//Missing instances are started, instances above n are stopped and removed,
+//the instances that stay are left untouched. Instances that fail to start are reported
+func ScaleJob(name string, n int) error {
+	job, ok := findJob(name)
+	if !ok {
+		return zistrpc.NotFound(name)
+	}
+	if n < 1 {
+		return zistrpc.Errorf(zistrpc.CodeInvalid, "[*] %s: can't scale below one instance, stop it instead", job.Name)
+	}
+	if err := job.checkNumProcs(n); err != nil {
+		return zistrpc.Errorf(zistrpc.CodeInvalid, "%s", err)
+	}
+	startLock.Lock()
+	defer startLock.Unlock()
+	running := jobInstances(job.Name)
+	for index, proc := range running {
+		if index < n {
+			continue
+		}
+		if err := proc.Kill(); err != nil {
+			return err
+		}
+		RemoveProcess(proc)
+	}
+	setNumProcs(job.Name, n)
+	var failed []string
+	for index := 0; index < n; index++ {
+		if _, ok := running[index]; ok {
+			continue
+		}
+		inst := job.instance(index)
+		cp, err := launchProcess(inst, 0)
+		if err != nil {
+			failed = append(failed, inst.Name+": "+err.Error())
+			continue
+		}
+		go cp.supervise()
+	}
+	if len(failed) > 0 {
+		return errors.New("[*] Failed to start " + strings.Join(failed, ", "))
+	}
+	return nil
+}
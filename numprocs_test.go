@@ -0,0 +1,72 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"github.com/ziscky/zist/zistrpc"
+	"os"
+	"sync"
+	"testing"
+)
+
+//TestScaleJob scales a job concurrently, every instance must be started once
+func TestScaleJob(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	activeProcesses = make(map[int]*ChildProcess)
+	jobsLock.Lock()
+	jobs = []Job{{Name: "worker-%(index)d", Path: "/bin/sleep", Args: "5"}}
+	jobsLock.Unlock()
+	defer func() {
+		for _, proc := range jobProcesses("") {
+			proc.Kill()
+		}
+		jobsLock.Lock()
+		jobs = nil
+		jobsLock.Unlock()
+	}()
+
+	err := ScaleJob("worker-%(index)d", 0)
+	if rpcErr, ok := err.(*zistrpc.Error); !ok || rpcErr.Code != zistrpc.CodeInvalid {
+		t.Fatalf("scaling to 0 got %v, want an invalid request", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ScaleJob("worker-%(index)d", 3); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	procs := jobProcesses("worker-%(index)d")
+	if len(procs) != 3 {
+		t.Fatalf("got %d instances, want 3", len(procs))
+	}
+	if job, _ := lookupJob("worker-%(index)d"); job.NumProcs != 3 {
+		t.Errorf("NumProcs is %d, want 3", job.NumProcs)
+	}
+	if err := ScaleJob("worker-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if running := jobInstances("worker-%(index)d"); len(running) != 1 || running[0] == nil {
+		t.Errorf("scaled down to %d instances, want worker-0 only", len(running))
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"bufio"
+	"errors"
+	"github.com/ziscky/zist/zistrpc"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//clockTicks is USER_HZ, the unit of the cpu times in /proc/<pid>/stat
+const clockTicks = 100
+
+//StatsWindow is how long Stats samples the process to work out its cpu percentage
+const StatsWindow = 500 * time.Millisecond
+
+//ReadProcStats reads the resource usage of pid from /proc.
+//CPUPercent is left at 0, it needs a second sample, see CPUPercentSince
+func ReadProcStats(pid int) (*zistrpc.ProcStats, error) {
+	dir := path.Join("/proc", strconv.Itoa(pid))
+	stats := &zistrpc.ProcStats{PID: pid, Time: time.Now()}
+	if err := readStat(stats, dir); err != nil {
+		return nil, err
+	}
+	if err := readStatus(stats, dir); err != nil {
+		return nil, err
+	}
+	//io is only readable by the owner of the process
+	if err := readIO(stats, dir); err != nil && !os.IsPermission(err) {
+		return nil, err
+	}
+	fds, err := ioutil.ReadDir(path.Join(dir, "fd"))
+	if err != nil && !os.IsPermission(err) {
+		return nil, err
+	}
+	stats.FDs = len(fds)
+	if total, err := memTotal(); err == nil && total > 0 {
+		stats.MemPercent = float64(stats.RSS) * 100 / float64(total)
+	}
+	return stats, nil
+}
+
+//readStat reads the parent, command and cpu times from /proc/<pid>/stat
+func readStat(stats *zistrpc.ProcStats, dir string) error {
+	command, fields, err := readStatFields(dir)
+	if err != nil {
+		return err
+	}
+	stats.Command = command
+	if stats.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return err
+	}
+	//utime and stime are fields 14 and 15 of stat, 12 and 13 after the command name
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return err
+	}
+	stats.CPUTime = float64(utime+stime) / clockTicks
+	return nil
+}
+
+//readStatFields splits /proc/<pid>/stat into the command name and the fields after it, starting with the state
+func readStatFields(dir string) (string, []string, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, "stat"))
+	if err != nil {
+		return "", nil, err
+	}
+	//the command name in parentheses may contain spaces and parentheses
+	start, end := strings.IndexByte(string(data), '('), strings.LastIndexByte(string(data), ')')
+	if start < 0 || end < start {
+		return "", nil, errors.New("[*] Malformed " + dir + "/stat")
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 13 {
+		return "", nil, errors.New("[*] Malformed " + dir + "/stat")
+	}
+	return string(data[start+1 : end]), fields, nil
+}
+
+//readStatus reads memory, threads and context switches from /proc/<pid>/status
+func readStatus(stats *zistrpc.ProcStats, dir string) error {
+	return readKeyValues(path.Join(dir, "status"), func(key string, value uint64) {
+		switch key {
+		case "VmRSS":
+			stats.RSS = value * 1024
+		case "VmSize":
+			stats.VSZ = value * 1024
+		case "Threads":
+			stats.Threads = int(value)
+		case "voluntary_ctxt_switches":
+			stats.VoluntaryCtxSwitches = value
+		case "nonvoluntary_ctxt_switches":
+			stats.InvoluntaryCtxSwitches = value
+		}
+	})
+}
+
+//readIO reads the storage bytes from /proc/<pid>/io
+func readIO(stats *zistrpc.ProcStats, dir string) error {
+	return readKeyValues(path.Join(dir, "io"), func(key string, value uint64) {
+		switch key {
+		case "read_bytes":
+			stats.ReadBytes = value
+		case "write_bytes":
+			stats.WriteBytes = value
+		}
+	})
+}
+
+//memTotal is the total memory in bytes from /proc/meminfo
+func memTotal() (uint64, error) {
+	var total uint64
+	err := readKeyValues("/proc/meminfo", func(key string, value uint64) {
+		if key == "MemTotal" {
+			total = value * 1024
+		}
+	})
+	return total, err
+}
+
+//readKeyValues calls fn for every "key: number [kB]" line of a /proc file, other lines are skipped
+func readKeyValues(file string, fn func(key string, value uint64)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		fn(parts[0], value)
+	}
+	return scanner.Err()
+}
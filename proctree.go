@@ -0,0 +1,83 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"github.com/ziscky/zist/zistrpc"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+//childPIDs maps every running process to the processes it started
+func childPIDs() (map[int][]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	children := map[int][]int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		//processes can exit while /proc is read
+		_, fields, err := readStatFields(path.Join("/proc", entry.Name()))
+		if err != nil {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+	}
+	for _, pids := range children {
+		sort.Ints(pids)
+	}
+	return children, nil
+}
+
+//ReadProcTree reads the stats of pid and all its descendants
+func ReadProcTree(pid int) (*zistrpc.ProcStats, error) {
+	children, err := childPIDs()
+	if err != nil {
+		return nil, err
+	}
+	return readProcNode(pid, children)
+}
+
+func readProcNode(pid int, children map[int][]int) (*zistrpc.ProcStats, error) {
+	stats, err := ReadProcStats(pid)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children[pid] {
+		node, err := readProcNode(child, children)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		stats.Children = append(stats.Children, node)
+	}
+	return stats, nil
+}
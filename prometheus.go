@@ -0,0 +1,210 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ziscky/zist/zistrpc"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//zistdStart is when zistd started, for zistd_start_time_seconds
+var zistdStart = time.Now()
+
+//processStates are the values of the zist_process_state label
+var processStates = []ProcessState{StateStarting, StateReady, StateUnhealthy, StateStopping, StateStopped, StateBackoff, StateFatal}
+
+//metricWriter writes metrics in the Prometheus text exposition format
+type metricWriter struct {
+	bytes.Buffer
+}
+
+//family writes the HELP and TYPE header of a metric
+func (mw *metricWriter) family(name, kind, help string) {
+	fmt.Fprintf(mw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+//sample writes a value of a metric with labels as alternating names and values
+func (mw *metricWriter) sample(name string, value float64, labels ...string) {
+	mw.WriteString(name)
+	if len(labels) > 0 {
+		mw.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.WriteByte(',')
+			}
+			mw.WriteString(labels[i] + "=" + strconv.Quote(labels[i+1]))
+		}
+		mw.WriteByte('}')
+	}
+	mw.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+//processMetric is a per process metric, value returns false when the process has no value for it
+type processMetric struct {
+	name string
+	kind string
+	help string
+	//value is called holding the process stateLock
+	value func(cp *ChildProcess, tree *zistrpc.ProcStats) (float64, bool)
+}
+
+var processMetrics = []processMetric{
+	{"zist_process_up", "gauge", "Whether the process is running.", func(cp *ChildProcess, _ *zistrpc.ProcStats) (float64, bool) {
+		return boolValue(cp.State.Running()), true
+	}},
+	{"zist_process_restarts_total", "counter", "Times the process has been restarted.", func(cp *ChildProcess, _ *zistrpc.ProcStats) (float64, bool) {
+		return float64(cp.RestartCount), true
+	}},
+	{"zist_process_last_exit_code", "gauge", "Exit code of the last run, -1 when it was killed by a signal.", func(cp *ChildProcess, _ *zistrpc.ProcStats) (float64, bool) {
+		if cp.LastExit == nil {
+			return 0, false
+		}
+		return float64(cp.LastExit.Code), true
+	}},
+	{"zist_process_start_time_seconds", "gauge", "Start time of the current run in seconds since the epoch.", func(cp *ChildProcess, _ *zistrpc.ProcStats) (float64, bool) {
+		return float64(cp.Timestamp.UnixNano()) / 1e9, !cp.Timestamp.IsZero()
+	}},
+	{"zist_process_cpu_seconds_total", "counter", "User and system cpu time of the process in seconds.", func(_ *ChildProcess, tree *zistrpc.ProcStats) (float64, bool) {
+		if tree == nil {
+			return 0, false
+		}
+		return tree.CPUTime, true
+	}},
+	//the tree sum drops when a descendant exits, so it can't be a counter
+	{"zist_process_tree_cpu_seconds", "gauge", "User and system cpu time of the live processes of the process tree in seconds.", func(_ *ChildProcess, tree *zistrpc.ProcStats) (float64, bool) {
+		if tree == nil {
+			return 0, false
+		}
+		return tree.Total.CPUTime, true
+	}},
+	{"zist_process_resident_memory_bytes", "gauge", "Resident memory of the process tree in bytes.", func(_ *ChildProcess, tree *zistrpc.ProcStats) (float64, bool) {
+		if tree == nil {
+			return 0, false
+		}
+		return float64(tree.Total.RSS), true
+	}},
+	{"zist_process_open_fds", "gauge", "Open file descriptors of the process tree.", func(_ *ChildProcess, tree *zistrpc.ProcStats) (float64, bool) {
+		if tree == nil {
+			return 0, false
+		}
+		return float64(tree.Total.FDs), true
+	}},
+	{"zist_process_health_check_failures_total", "counter", "Failed health check probes.", func(cp *ChildProcess, _ *zistrpc.ProcStats) (float64, bool) {
+		return float64(cp.HealthFailuresTotal), cp.Conf.HealthCheck != nil
+	}},
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//Metrics serves the supervised process and zistd metrics to Prometheus
+func Metrics(rw http.ResponseWriter, r *http.Request) {
+	procs := jobProcesses("")
+	sort.Slice(procs, func(i, j int) bool { return procs[i].Pname < procs[j].Pname })
+	trees := make([]*zistrpc.ProcStats, len(procs))
+	for i, cp := range procs {
+		cp.stateLock.Lock()
+		running, pid := cp.State.Running(), cp.PID
+		cp.stateLock.Unlock()
+		if !running {
+			continue
+		}
+		if tree, err := ReadProcTree(pid); err == nil {
+			tree.SumTree()
+			trees[i] = tree
+		}
+	}
+
+	mw := new(metricWriter)
+	mw.family("zist_process_state", "gauge", "Lifecycle state of the process, 1 for the current state.")
+	for _, cp := range procs {
+		current := cp.currentState()
+		for _, state := range processStates {
+			mw.sample("zist_process_state", boolValue(current == state), "name", cp.Pname, "job_name", cp.Conf.Template, "state", string(state))
+		}
+	}
+	for _, m := range processMetrics {
+		mw.family(m.name, m.kind, m.help)
+		for i, cp := range procs {
+			cp.stateLock.Lock()
+			v, ok := m.value(cp, trees[i])
+			cp.stateLock.Unlock()
+			if ok {
+				mw.sample(m.name, v, "name", cp.Pname, "job_name", cp.Conf.Template)
+			}
+		}
+	}
+	writeZistdMetrics(mw, len(procs))
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.Write(mw.Bytes())
+}
+
+//writeZistdMetrics writes the metrics of zistd itself
+func writeZistdMetrics(mw *metricWriter, procs int) {
+	mw.family("zistd_start_time_seconds", "gauge", "Start time of zistd in seconds since the epoch.")
+	mw.sample("zistd_start_time_seconds", float64(zistdStart.UnixNano())/1e9)
+	mw.family("zistd_jobs", "gauge", "Configured jobs.")
+	mw.sample("zistd_jobs", float64(len(configuredJobs())))
+	mw.family("zistd_processes", "gauge", "Supervised processes.")
+	mw.sample("zistd_processes", float64(procs))
+	mw.family("zistd_goroutines", "gauge", "Goroutines of zistd.")
+	mw.sample("zistd_goroutines", float64(runtime.NumGoroutine()))
+	stats, err := ReadProcStats(os.Getpid())
+	if err != nil {
+		return
+	}
+	mw.family("zistd_cpu_seconds_total", "counter", "User and system cpu time of zistd in seconds.")
+	mw.sample("zistd_cpu_seconds_total", stats.CPUTime)
+	mw.family("zistd_resident_memory_bytes", "gauge", "Resident memory of zistd in bytes.")
+	mw.sample("zistd_resident_memory_bytes", float64(stats.RSS))
+	mw.family("zistd_open_fds", "gauge", "Open file descriptors of zistd.")
+	mw.sample("zistd_open_fds", float64(stats.FDs))
+}
+
+//CheckMetricsAuth protects the metrics route as set by MetricsAuth
+func CheckMetricsAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		switch appConf.MetricsAuth {
+		case "none":
+		case "basic":
+			user, password, ok := r.BasicAuth()
+			if !ok || !secureEqual(user, appConf.MetricsUser) || !secureEqual(password, appConf.MetricsPassword) {
+				rw.Header().Set("WWW-Authenticate", `Basic realm="zistd"`)
+				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		default:
+			if !secureEqual(bearerToken(r), appConf.Token) {
+				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(rw, r)
+	}
+}
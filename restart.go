@@ -0,0 +1,133 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"github.com/ziscky/zist/zistrpc"
+	"math/rand"
+	"time"
+)
+
+//RestartPolicy decides when an exited process is restarted
+type RestartPolicy string
+
+const (
+	//RestartAlways restarts the process whenever it exits
+	RestartAlways RestartPolicy = "always"
+	//RestartOnFailure restarts the process unless it exits with an expected exit code
+	RestartOnFailure RestartPolicy = "on-failure"
+	//RestartNever leaves the process stopped
+	RestartNever RestartPolicy = "never"
+)
+
+const (
+	//DefaultStartSecs is how long a process must stay up to count as started
+	DefaultStartSecs = 10
+	//DefaultMaxRetries is how many consecutive failed starts are retried before giving up
+	DefaultMaxRetries = 3
+	//DefaultBackoff is the delay before the first retry of a failed start
+	DefaultBackoff = 1 * time.Second
+	//DefaultMaxBackoff caps the delay between retries
+	DefaultMaxBackoff = 60 * time.Second
+)
+
+//UnmarshalTOML reads the policy from its name or from the older Restart = true/false form
+func (rp *RestartPolicy) UnmarshalTOML(v interface{}) error {
+	switch value := v.(type) {
+	case bool:
+		*rp = RestartNever
+		if value {
+			*rp = RestartAlways
+		}
+		return nil
+	case string:
+		switch policy := RestartPolicy(value); policy {
+		case "", RestartAlways, RestartOnFailure, RestartNever:
+			*rp = policy
+			return nil
+		}
+	}
+	return errors.New("[*] Restart must be one of always, on-failure or never")
+}
+
+//restarts checks if the job restarts a process that exited with the given status
+func (job Job) restarts(status *zistrpc.ExitStatus) bool {
+	switch job.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return !job.expectedExit(status)
+	}
+	return false
+}
+
+//expectedExit checks if the process exited cleanly with one of ExpectedExitCodes, by default 0
+func (job Job) expectedExit(status *zistrpc.ExitStatus) bool {
+	if status.Signal != "" {
+		return false
+	}
+	if len(job.ExpectedExitCodes) == 0 {
+		return status.Code == 0
+	}
+	for _, code := range job.ExpectedExitCodes {
+		if status.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+//startSecs returns how long the process must stay up for a start to count as successful
+func (job Job) startSecs() time.Duration {
+	if job.StartSecs <= 0 {
+		return DefaultStartSecs * time.Second
+	}
+	return time.Duration(job.StartSecs) * time.Second
+}
+
+//maxRetries returns the number of consecutive failed starts retried, negative is unlimited
+func (job Job) maxRetries() int {
+	if job.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return job.MaxRetries
+}
+
+//backoff returns the delay before the next start after the given consecutive failures.
+//The delay doubles on every failure with up to 25% jitter and is capped at MaxBackoff
+func (job Job) backoff(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	delay, limit := DefaultBackoff, DefaultMaxBackoff
+	if job.Backoff > 0 {
+		delay = time.Duration(job.Backoff) * time.Second
+	}
+	if job.MaxBackoff > 0 {
+		limit = time.Duration(job.MaxBackoff) * time.Second
+	}
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	delay += time.Duration(rand.Int63n(int64(delay)/4 + 1))
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"github.com/ziscky/zist/zistrpc"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//DefaultBufferLines is the number of output lines kept per stream when the job sets no limit
+const DefaultBufferLines = 1000
+
+//RingBuffer keeps the most recent lines of a stream within a line and a byte limit.
+//Lines are numbered with increasing sequence numbers so readers can resume where they left off
+type RingBuffer struct {
+	maxLines int
+	maxBytes int
+	lines    []zistrpc.LogLine
+	head     int //index of the oldest kept line
+	bytes    int
+	seq      uint64        //sequence number of the newest line
+	changed  chan struct{} //closed and replaced on every Append
+	lock     sync.RWMutex
+}
+
+//NewRingBuffer creates a buffer holding at most maxLines lines and maxBytes bytes of text,
+//a zero limit is unbounded but at least one of them is always set
+func NewRingBuffer(maxLines, maxBytes int) *RingBuffer {
+	if maxLines <= 0 && maxBytes <= 0 {
+		maxLines = DefaultBufferLines
+	}
+	return &RingBuffer{maxLines: maxLines, maxBytes: maxBytes, changed: make(chan struct{})}
+}
+
+//Append numbers, timestamps and adds a line, dropping the oldest lines that no longer fit.
+//A line longer than the byte limit is cut to it so the newest line is always kept
+func (rb *RingBuffer) Append(line zistrpc.LogLine) zistrpc.LogLine {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.seq++
+	line.Seq, line.Time = rb.seq, time.Now()
+	if rb.maxBytes > 0 && len(line.Text) > rb.maxBytes {
+		line.Text = truncateText(line.Text, rb.maxBytes)
+	}
+	rb.lines = append(rb.lines, line)
+	rb.bytes += len(line.Text)
+	for rb.len() > 0 && ((rb.maxLines > 0 && rb.len() > rb.maxLines) || (rb.maxBytes > 0 && rb.bytes > rb.maxBytes)) {
+		rb.bytes -= len(rb.lines[rb.head].Text)
+		rb.lines[rb.head] = zistrpc.LogLine{}
+		rb.head++
+	}
+	//reclaim the dropped lines once they take up half the slice
+	if rb.head > len(rb.lines)/2 {
+		rb.lines = append([]zistrpc.LogLine(nil), rb.lines[rb.head:]...)
+		rb.head = 0
+	}
+	close(rb.changed)
+	rb.changed = make(chan struct{})
+	return line
+}
+
+//truncateText cuts s to at most max bytes without splitting a utf-8 character
+func truncateText(s string, max int) string {
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+func (rb *RingBuffer) len() int {
+	return len(rb.lines) - rb.head
+}
+
+//Lines returns all kept lines, oldest first
+func (rb *RingBuffer) Lines() []zistrpc.LogLine {
+	return rb.Since(0)
+}
+
+//Since returns the kept lines with a sequence number above seq, oldest first
+func (rb *RingBuffer) Since(seq uint64) []zistrpc.LogLine {
+	rb.lock.RLock()
+	defer rb.lock.RUnlock()
+	kept := rb.lines[rb.head:]
+	if len(kept) == 0 || seq >= rb.seq {
+		return []zistrpc.LogLine{}
+	}
+	//sequence numbers are contiguous so the position of seq is known
+	first := kept[0].Seq
+	if seq >= first {
+		kept = kept[seq-first+1:]
+	}
+	return append([]zistrpc.LogLine(nil), kept...)
+}
+
+//Last returns the newest n kept lines, oldest first
+func (rb *RingBuffer) Last(n int) []zistrpc.LogLine {
+	rb.lock.RLock()
+	defer rb.lock.RUnlock()
+	kept := rb.lines[rb.head:]
+	if n < len(kept) {
+		kept = kept[len(kept)-n:]
+	}
+	return append([]zistrpc.LogLine{}, kept...)
+}
+
+//Wait returns the lines after seq, blocking until there are some,
+//timeout passes or cancel is closed
+func (rb *RingBuffer) Wait(seq uint64, timeout time.Duration, cancel <-chan struct{}) []zistrpc.LogLine {
+	rb.lock.RLock()
+	changed, newer := rb.changed, rb.seq > seq
+	rb.lock.RUnlock()
+	if !newer {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-cancel:
+		}
+	}
+	return rb.Since(seq)
+}
+
+//Clear drops all kept lines, sequence numbers keep increasing
+func (rb *RingBuffer) Clear() {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.lines = nil
+	rb.head = 0
+	rb.bytes = 0
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"github.com/ziscky/zist/zistrpc"
+	"testing"
+)
+
+func TestRingBufferSince(t *testing.T) {
+	buf := NewRingBuffer(3, 0)
+	for _, text := range []string{"a", "b", "c", "d", "e"} {
+		buf.Append(zistrpc.LogLine{Text: text})
+	}
+	tests := []struct {
+		seq  uint64
+		want string
+	}{
+		{0, "cde"},
+		{1, "cde"}, //dropped lines are skipped
+		{2, "cde"},
+		{3, "de"},
+		{4, "e"},
+		{5, ""},
+		{9, ""},
+	}
+	for _, test := range tests {
+		var got string
+		for _, line := range buf.Since(test.seq) {
+			got += line.Text
+		}
+		if got != test.want {
+			t.Errorf("Since(%d) = %q, want %q", test.seq, got, test.want)
+		}
+	}
+	if lines := buf.Since(2); lines[0].Seq != 3 {
+		t.Errorf("Since(2) starts at seq %d, want 3", lines[0].Seq)
+	}
+}
@@ -19,6 +19,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ziscky/zist/zistrpc"
 	"log"
 	"net/http"
 	"strconv"
@@ -76,7 +79,7 @@ func WithProcess(next http.HandlerFunc) http.HandlerFunc {
 			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
-		proc, exists := activeProcesses[pid]
+		proc, exists := lookupProcess(pid)
 		if !exists {
 			http.Error(rw, "Process Does Not Exist", http.StatusNotFound)
 			return
@@ -103,20 +106,28 @@ func Stats(rw http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(rw).Encode(stats)
 }
 
+//StatsHistory returns the sampled resource usage of the process tree and its min, avg and max.
+//?since= takes an RFC3339 time or a duration ago i.e 1h, the whole history by default
+func StatsHistory(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	defer RemoveVars(r)
+	if !proc.EStats {
+		rw.Write([]byte("Not allowed"))
+		return
+	}
+	since, err := ParseLogTime(r.FormValue("since"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(rw).Encode(proc.StatsHistory(since))
+}
+
 //Default is the default API route ,returns all monitored processs info
 func Default(rw http.ResponseWriter, r *http.Request) {
-	procs := []map[string]interface{}{}
-	for _, proc := range activeProcesses {
-		procs = append(procs, map[string]interface{}{
-			"pid":         proc.PID,
-			"name":        proc.Pname,
-			"path":        proc.PPath,
-			"args":        proc.Args,
-			"numrestarts": proc.RestartCount,
-			"timestarted": proc.Timestamp.String(),
-			"timealive":   time.Since(proc.Timestamp).String(),
-			"isalive":     proc.IsAlive,
-		})
+	procs := []zistrpc.ProcessInfo{}
+	for _, proc := range jobProcesses("") {
+		procs = append(procs, proc.Info())
 	}
 	json.NewEncoder(rw).Encode(procs)
 }
@@ -172,7 +183,23 @@ func Detach(rw http.ResponseWriter, r *http.Request) {
 	rw.Write([]byte(proc.Pname + " has been successfully detached. I will no longer restart it if it fails,give you stdstreams  or give stats"))
 }
 
-//StdOut gets the process stdout
+//Scale changes the number of instances of the job the process belongs to
+func Scale(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	defer RemoveVars(r)
+	n, err := strconv.Atoi(mux.Vars(r)["n"])
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ScaleJob(proc.Pname, n); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw.Write([]byte(proc.Pname + " scaled to " + strconv.Itoa(n)))
+}
+
+//StdOut gets the process stdout, ?since=seq only returns the newer lines
 func StdOut(rw http.ResponseWriter, r *http.Request) {
 	proc := GetVar(r, "proc").(*ChildProcess)
 	defer RemoveVars(r)
@@ -180,10 +207,15 @@ func StdOut(rw http.ResponseWriter, r *http.Request) {
 		rw.Write([]byte("Not allowed"))
 		return
 	}
-	json.NewEncoder(rw).Encode(proc.GetOutput())
+	since, err := sinceParam(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(rw).Encode(proc.GetOutput(since))
 }
 
-//StdErr gets the process stderr
+//StdErr gets the process stderr, ?since=seq only returns the newer lines
 func StdErr(rw http.ResponseWriter, r *http.Request) {
 	proc := GetVar(r, "proc").(*ChildProcess)
 	defer RemoveVars(r)
@@ -191,18 +223,161 @@ func StdErr(rw http.ResponseWriter, r *http.Request) {
 		rw.Write([]byte("Not allowed"))
 		return
 	}
-	json.NewEncoder(rw).Encode(proc.GetErrors())
+	since, err := sinceParam(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(rw).Encode(proc.GetErrors(since))
+}
+
+//Logs gets the merged, time ordered stdout and stderr of the process as JSON Lines.
+//?from= and ?to= take RFC3339 times or durations ago i.e 15m, ?match= a regular expression
+func Logs(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	defer RemoveVars(r)
+	if !proc.EStdOut && !proc.EStdErr {
+		rw.Write([]byte("Not allowed"))
+		return
+	}
+	from, err := ParseLogTime(r.FormValue("from"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := ParseLogTime(r.FormValue("to"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lines, err := proc.Logs(LogFilter{From: from, To: to, Match: r.FormValue("match"), Stdout: proc.EStdOut, Stderr: proc.EStdErr})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.Write([]byte(JSONLines(lines)))
+}
+
+//StdOutStream follows the process stdout as Server-Sent Events
+func StdOutStream(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	RemoveVars(r)
+	if !proc.EStdOut {
+		rw.Write([]byte("Not allowed"))
+		return
+	}
+	streamLines(rw, r, proc.Output)
+}
+
+//StdErrStream follows the process stderr as Server-Sent Events
+func StdErrStream(rw http.ResponseWriter, r *http.Request) {
+	proc := GetVar(r, "proc").(*ChildProcess)
+	RemoveVars(r)
+	if !proc.EStdErr {
+		rw.Write([]byte("Not allowed"))
+		return
+	}
+	streamLines(rw, r, proc.Errors)
+}
+
+//streamLines sends every new line of buf as an event with the sequence number as its id
+//until the client goes away. ?since= or the Last-Event-ID header resume after a sequence number,
+//?lines=N starts with the newest N lines
+func streamLines(rw http.ResponseWriter, r *http.Request, buf *RingBuffer) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" && r.FormValue("since") == "" {
+		r.Form.Set("since", id)
+	}
+	since, err := sinceParam(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var lines []zistrpc.LogLine
+	if n, _ := strconv.Atoi(r.FormValue("lines")); n > 0 && since == 0 {
+		lines = buf.Last(n)
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	for {
+		for _, line := range lines {
+			data, _ := json.Marshal(line)
+			if _, err := fmt.Fprintf(rw, "id: %d\ndata: %s\n\n", line.Seq, data); err != nil {
+				return
+			}
+			since = line.Seq
+		}
+		//keep the connection alive through proxies while the process is quiet
+		if len(lines) == 0 {
+			if _, err := rw.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+		lines = buf.Wait(since, 15*time.Second, r.Context().Done())
+	}
+}
+
+//sinceParam reads the optional ?since= sequence number of output requests
+func sinceParam(r *http.Request) (uint64, error) {
+	since := r.FormValue("since")
+	if since == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(since, 10, 64)
+}
+
+//startStopped starts a process that isn't supervised, starting a running
+//or restarting process is a conflict
+func startStopped(proc *ChildProcess) error {
+	if proc.Supervised() {
+		info := proc.Info()
+		if info.NextAttempt != "" {
+			return zistrpc.Errorf(zistrpc.CodeConflict, "%s restarts at %s", proc.Pname, info.NextAttempt)
+		}
+		return zistrpc.Errorf(zistrpc.CodeConflict, "%s is %s", proc.Pname, info.State)
+	}
+	return startProcess(proc, proc.PID, 0)
+}
+
+//restartProcess stops the process and starts it under a new supervisor
+func restartProcess(proc *ChildProcess) error {
+	if err := proc.Kill(); err != nil {
+		return err
+	}
+	return startProcess(proc, proc.PID, proc.RestartCount+1)
 }
 
-//startProcess starts the requested child process
+//startProcess starts the requested child process under a new supervisor
+//the previous supervisor must have returned i.e after Kill
 func startProcess(proc *ChildProcess, pid, numrestarts int) error {
-	procstrct := Job{
-		Path: proc.PPath,
+	proc.runLock.Lock()
+	defer proc.runLock.Unlock()
+	if proc.supervised() {
+		return errors.New("Process already running")
 	}
-	if err := proc.Initialize(procstrct, numrestarts); err != nil {
+	proc.stateLock.Lock()
+	proc.Failures = 0
+	proc.stateLock.Unlock()
+	if err := proc.Initialize(proc.Conf, numrestarts); err != nil {
+		proc.closeLogs()
 		return err
 	}
-	proc.IsAlive = true
+	proc.KillSwitch = false
+	proc.done = make(chan struct{})
+	proc.halt = make(chan struct{}, 1)
+	go proc.supervise()
 	AddProcess(proc)
 
 	cp := new(ChildProcess)
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+//SplitArgs splits a command line string into argv following POSIX shell word rules.
+//Single quotes keep everything literally, double quotes allow \ to escape $ ` " \ and newline,
+//an unquoted \ escapes the next character. No variable or glob expansion is done.
+func SplitArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+	)
+	for _, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+			if c == '\n' { //line continuation
+				continue
+			}
+			if quote == '"' && !strings.ContainsRune("$`\"\\", c) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(c)
+			inWord = true
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+				continue
+			}
+			word.WriteRune(c)
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if escaped {
+		return nil, errors.New("[*] Trailing backslash in args: " + line)
+	}
+	if quote != 0 {
+		return nil, errors.New("[*] Unterminated quote in args: " + line)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+//argv returns the job arguments, Argv takes them as is while Args is split with shell word rules
+func (job Job) argv() ([]string, error) {
+	if len(job.Argv) > 0 {
+		return job.Argv, nil
+	}
+	return SplitArgs(job.Args)
+}
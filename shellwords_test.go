@@ -0,0 +1,63 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  \t ", nil},
+		{"one two  three", []string{"one", "two", "three"}},
+		{"one 'two three' four", []string{"one", "two three", "four"}},
+		{`say "hello world"`, []string{"say", "hello world"}},
+		{`'$HOME \n'`, []string{`$HOME \n`}},
+		{`"a\"b" "c\\d" "\$x" "\q"`, []string{`a"b`, `c\d`, `$x`, `\q`}},
+		{`a\ b \'c\' \\`, []string{"a b", "'c'", `\`}},
+		{`'' "" x`, []string{"", "", "x"}},
+		{`'it'\''s'`, []string{"it's"}},
+		{`pre"mid"'post'`, []string{"premidpost"}},
+		{"one \\\ntwo", []string{"one", "two"}},
+		{"con\\\ntinued", []string{"continued"}},
+		{"\"con\\\ntinued\"", []string{"continued"}},
+		{"'keep\\\nnewline'", []string{"keep\\\nnewline"}},
+		{"a\nb", []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		got, err := SplitArgs(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.line, got, test.want)
+		}
+	}
+
+	for _, line := range []string{`'open`, `"open`, `"a\"`, `trailing\`, `'it's'`} {
+		if args, err := SplitArgs(line); err == nil {
+			t.Errorf("%q: got %q, want an error", line, args)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	//DefaultStopSignal is sent to a process on stop when the job doesn't set StopSignal
+	DefaultStopSignal = syscall.SIGTERM
+	//DefaultStopTimeout is how long a process gets to exit before it is sent SIGKILL
+	DefaultStopTimeout = 10 * time.Second
+	//OutputWaitDelay is how long zistd keeps reading the output of a process that exited
+	//while processes it left behind still hold its stdout or stderr
+	OutputWaitDelay = 2 * time.Second
+)
+
+var signals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+//ParseSignal converts a signal name i.e TERM or SIGTERM to its syscall value
+func ParseSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return DefaultStopSignal, nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, errors.New("[*] Unknown signal " + name)
+	}
+	return sig, nil
+}
+
+//stopTimeout returns how long zistd waits for the process to exit after the stop signal
+func (job Job) stopTimeout() time.Duration {
+	if job.StopTimeout <= 0 {
+		return DefaultStopTimeout
+	}
+	return time.Duration(job.StopTimeout) * time.Second
+}
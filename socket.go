@@ -0,0 +1,209 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const (
+	//DefaultSocket is the unix socket local zistcl connects to
+	DefaultSocket = "/run/zist/zistd.sock"
+	//DefaultSocketMode lets the socket owner and group connect
+	DefaultSocketMode = 0660
+)
+
+type peerCredKey struct{}
+
+//socketPath is the control socket, empty when Socket is none
+func socketPath() string {
+	switch appConf.Socket {
+	case "":
+		return DefaultSocket
+	case "none":
+		return ""
+	}
+	return appConf.Socket
+}
+
+//socketMode is the permission bits of the control socket
+func socketMode() (os.FileMode, error) {
+	if appConf.SocketMode == "" {
+		return DefaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(appConf.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("[*] Invalid SocketMode " + appConf.SocketMode + ", use an octal mode i.e 0660")
+	}
+	return os.FileMode(mode), nil
+}
+
+//socketOwner resolves SocketOwner and SocketGroup, -1 leaves them as they are
+func socketOwner() (int, int, error) {
+	uid, gid := -1, -1
+	if appConf.SocketOwner != "" {
+		u, err := lookupUser(appConf.SocketOwner)
+		if err != nil {
+			return 0, 0, errors.New("[*] Unknown SocketOwner " + appConf.SocketOwner)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if appConf.SocketGroup != "" {
+		g, err := lookupGID(appConf.SocketGroup)
+		if err != nil {
+			return 0, 0, errors.New("[*] Unknown SocketGroup " + appConf.SocketGroup)
+		}
+		gid = int(g)
+	}
+	return uid, gid, nil
+}
+
+//listenSocket serves RPC on the control socket to local callers authorized by PeerAuth
+func listenSocket(server *rpc.Server) error {
+	sock := socketPath()
+	if sock == "" {
+		return nil
+	}
+	mode, err := socketMode()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := socketOwner()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sock), 0755); err != nil {
+		return err
+	}
+	//a socket left behind by a zistd that didn't exit cleanly
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return errors.New("[*] " + sock + " is in use by another zistd")
+	}
+	if info, err := os.Lstat(sock); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(sock)
+	}
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(sock, mode); err != nil {
+		listener.Close()
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(sock, uid, gid); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, PeerAuth(server))
+	srv := &http.Server{
+		Handler: mux,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, peerCredKey{}, peerCred(c))
+		},
+	}
+	go func() {
+		log.Println(srv.Serve(listener).Error())
+	}()
+	return nil
+}
+
+//peerCred gets the uid and gid of the process on the other end of a unix socket
+func peerCred(c net.Conn) *syscall.Ucred {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil
+	}
+	return cred
+}
+
+//peerAllowed checks if a caller may control zistd: root, zistd's user, the socket owner
+//or a caller in the socket group, as its primary or a supplementary group
+func peerAllowed(cred *syscall.Ucred) bool {
+	if cred == nil {
+		return false
+	}
+	if cred.Uid == 0 || int(cred.Uid) == os.Getuid() {
+		return true
+	}
+	uid, gid, err := socketOwner()
+	if err != nil {
+		return false
+	}
+	if uid != -1 && int(cred.Uid) == uid {
+		return true
+	}
+	return gid != -1 && (int(cred.Gid) == gid || inGroup(cred.Uid, gid))
+}
+
+//inGroup checks if the user is a supplementary member of the group
+func inGroup(uid uint32, gid int) bool {
+	u, err := user.LookupId(strconv.Itoa(int(uid)))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, g := range groups {
+		if g == strconv.Itoa(gid) {
+			return true
+		}
+	}
+	return false
+}
+
+//PeerAuth only lets callers allowed by peerAllowed through to the RPC server, in place of the token
+func PeerAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		cred, _ := r.Context().Value(peerCredKey{}).(*syscall.Ucred)
+		if !peerAllowed(cred) {
+			if cred != nil {
+				log.Println("Rejected control socket connection from uid", cred.Uid, "gid", cred.Gid)
+			}
+			http.Error(rw, "zistd: not allowed to use the control socket", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
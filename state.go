@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+//ProcessState is where a supervised process is in its lifecycle
+type ProcessState string
+
+const (
+	//StateStarting is a process that is running but not ready yet
+	StateStarting ProcessState = "starting"
+	//StateReady is a process that passed its readiness check, or stayed up for StartSecs without one
+	StateReady ProcessState = "ready"
+	//StateUnhealthy is a process failing its health or readiness checks
+	StateUnhealthy ProcessState = "unhealthy"
+	//StateStopping is a process that has been sent its stop signal
+	StateStopping ProcessState = "stopping"
+	//StateStopped is a process that exited and won't be restarted
+	StateStopped ProcessState = "stopped"
+	//StateBackoff is a process waiting to be restarted
+	StateBackoff ProcessState = "backoff"
+	//StateFatal is a process the restart policy gave up on
+	StateFatal ProcessState = "fatal"
+)
+
+//Running checks if the state is one of a running process
+func (state ProcessState) Running() bool {
+	switch state {
+	case StateStarting, StateReady, StateUnhealthy, StateStopping:
+		return true
+	}
+	return false
+}
+
+//Running checks if the process is running in any state
+func (cp *ChildProcess) Running() bool {
+	return cp.currentState().Running()
+}
+
+//currentState reads the process state
+func (cp *ChildProcess) currentState() ProcessState {
+	cp.stateLock.Lock()
+	defer cp.stateLock.Unlock()
+	return cp.State
+}
+
+//setState moves the process to state
+func (cp *ChildProcess) setState(state ProcessState) {
+	cp.stateLock.Lock()
+	defer cp.stateLock.Unlock()
+	cp.State = state
+}
+
+//transition moves the process to state only if it's currently in one of from
+func (cp *ChildProcess) transition(state ProcessState, from ...ProcessState) bool {
+	cp.stateLock.Lock()
+	defer cp.stateLock.Unlock()
+	for _, s := range from {
+		if cp.State == s {
+			cp.State = state
+			return true
+		}
+	}
+	return false
+}
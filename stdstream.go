@@ -19,20 +19,68 @@ package main
 
 import (
 	"bufio"
+	"github.com/ziscky/zist/zistrpc"
+	"io"
+	"log"
 )
 
-//LogStdOut redirects the process stdout to the cp array
-func LogStdOut(cp *ChildProcess) {
-	stdOutScanner := bufio.NewScanner(cp.StdOutR)
-	for stdOutScanner.Scan() {
-		cp.AppendOutput(stdOutScanner.Text())
+//OutputStream is stdout or stderr of a run of the process and where its lines go.
+//It's taken when the run starts so a later run or closeLogs doesn't change it underneath
+type OutputStream struct {
+	Name       string //stdout or stderr
+	Reader     io.Reader
+	Buffer     *RingBuffer
+	Log        *RotatingFile
+	Syslog     *SyslogWriter
+	PID        int
+	Generation int
+}
+
+//MaxLineBytes is the longest output line kept, the rest of a longer line is dropped
+const MaxLineBytes = 64 * 1024
+
+//LogStream redirects a process stream to the cp buffer, the job Logfile and syslog.
+//It reads until the process closes the stream so the process never blocks writing to it
+func LogStream(out OutputStream) {
+	reader := bufio.NewReaderSize(out.Reader, MaxLineBytes)
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return
+		}
+		out.Buffer.Append(zistrpc.LogLine{Stream: out.Name, PID: out.PID, Generation: out.Generation, Text: line})
+		writeLog(out.Log, line)
+		writeSyslog(out.Syslog, out.Name, out.PID, line)
+	}
+}
+
+//readLine reads a line without its line ending, a line longer than the reader
+//buffer is cut to it and the rest of the line is skipped
+func readLine(reader *bufio.Reader) (string, error) {
+	line, isPrefix, err := reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if !isPrefix {
+		return string(line), nil
+	}
+	//don't split the character at the cut
+	text := string(line)
+	if next, _ := reader.Peek(1); len(next) > 0 {
+		text = truncateText(text+string(next), len(line))
 	}
+	for isPrefix && err == nil {
+		_, isPrefix, err = reader.ReadLine()
+	}
+	return text, nil
 }
 
-//LogStdErr redirects the process stderr to the cp array
-func LogStdErr(cp *ChildProcess) {
-	stdErrScanner := bufio.NewScanner(cp.StdErrR)
-	for stdErrScanner.Scan() {
-		cp.AppendError(stdErrScanner.Text())
+//writeLog appends a line to the log file if the job has one
+func writeLog(rf *RotatingFile, line string) {
+	if rf == nil {
+		return
+	}
+	if _, err := rf.Write([]byte(line + "\n")); err != nil {
+		log.Println(err)
 	}
 }
@@ -0,0 +1,56 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+//TestLogStreamLongLine checks a line over MaxLineBytes is cut and the lines after it still read
+func TestLogStreamLongLine(t *testing.T) {
+	r, w := io.Pipe()
+	buf := NewRingBuffer(10, 0)
+	done := make(chan struct{})
+	go func() {
+		LogStream(OutputStream{Name: "stdout", Reader: r, Buffer: buf})
+		close(done)
+	}()
+	long := strings.Repeat("x", MaxLineBytes-1) + "é" + strings.Repeat("y", 3*MaxLineBytes)
+	if _, err := io.WriteString(w, "first\n"+long+"\nlast\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("LogStream stopped draining the stream")
+	}
+	lines := buf.Lines()
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	if lines[0].Text != "first" || lines[2].Text != "last" {
+		t.Errorf("got %q and %q around the long line", lines[0].Text, lines[2].Text)
+	}
+	if want := strings.Repeat("x", MaxLineBytes-1); lines[1].Text != want {
+		t.Errorf("long line cut to %d bytes, want %d", len(lines[1].Text), len(want))
+	}
+}
@@ -19,16 +19,21 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -47,6 +52,14 @@ func AddProcess(cp *ChildProcess) {
 	activeProcesses[cp.PID] = cp
 }
 
+//lookupProcess gets a monitored process by pid
+func lookupProcess(pid int) (*ChildProcess, bool) {
+	procLock.RLock()
+	defer procLock.RUnlock()
+	proc, exists := activeProcesses[pid]
+	return proc, exists
+}
+
 //RemoveProcess removes a process from the process map
 func RemoveProcess(cp *ChildProcess) {
 	procLock.Lock()
@@ -55,61 +68,130 @@ func RemoveProcess(cp *ChildProcess) {
 }
 
 //RegisterProcess initializes a process and adds it to the proccess map
+//then supervises it until it's stopped or the restart policy gives up
 func RegisterProcess(ps Job, rcount int) error {
+	cp, err := launchProcess(ps, rcount)
+	if err != nil {
+		return err
+	}
+	cp.supervise()
+	return nil
+}
+
+//launchProcess initializes a process and adds it to the proccess map, the caller supervises it
+func launchProcess(ps Job, rcount int) (*ChildProcess, error) {
 	cp := new(ChildProcess)
+	cp.Output = NewRingBuffer(ps.BufferLines, ps.BufferBytes)
+	cp.Errors = NewRingBuffer(ps.BufferLines, ps.BufferBytes)
+	cp.History = NewMetricsHistory(statsHistory())
 	log.Println(ps.Name)
 	if _, err := os.Stat(ps.Path); os.IsNotExist(err) {
 		log.Println(ps.Path, " does not exist")
-		return err
+		return nil, err
 	}
 
-	if err := cp.Initialize(ps, rcount); err != nil {
-		log.Println("initialize", err)
-		return err
-	}
+	//set up before Initialize, the health, readiness and limit monitors it starts read them
 	cp.Pname = ps.Name
 	cp.PPath = ps.Path
 	cp.Args = ps.Args
-	cp.IsAlive = true
+	if len(ps.Argv) > 0 {
+		cp.Args = strings.Join(ps.Argv, " ")
+	}
+	cp.Conf = ps
+	cp.StopSignal, _ = ParseSignal(ps.StopSignal)
+	cp.StopTimeout = ps.stopTimeout()
 	//web statistics settings
 	if appConf.Web {
 		cp.EStats = ps.Web
 		cp.EStdErr = ps.Web
 		cp.EStdOut = ps.Web
 	}
-	//Start logging the process stdout
-	go LogStdOut(cp)
-	//Start logging the process stderr
-	go LogStdErr(cp)
+	cp.done = make(chan struct{})
+	cp.halt = make(chan struct{}, 1)
+
+	if err := cp.Initialize(ps, rcount); err != nil {
+		log.Println("initialize", err)
+		cp.closeLogs()
+		return nil, err
+	}
 
 	AddProcess(cp)
 	fmt.Println("[*]", cp.Pname, "started successfully.")
-	if err := cp.Proc.Wait(); err != nil {
-		fmt.Println("[*]", cp.Pname, "Non zero exit: ", err)
-	}
-
-	if !cp.KillSwitch {
-		if ps.Restart { //restart process
-			//crash report
-			if time.Since(cp.Timestamp).Seconds() > 10 {
-				RemoveProcess(cp)
-				return RegisterProcess(ps, cp.RestartCount+1)
-			} else {
-				log.Println(cp.Pname, "is exiting too quick. Backing off. Start Explicitly")
-				if _, ok := activeProcesses[cp.PID]; ok {
-					activeProcesses[cp.PID].IsAlive = false
-				}
-				return nil
-			}
+	return cp, nil
+}
+
+//supervise waits for the process to exit and restarts it according to the job restart policy.
+//Failed starts, exits within StartSecs, are retried with backoff up to MaxRetries times in a row
+func (cp *ChildProcess) supervise() {
+	defer close(cp.done)
+	defer cp.closeLogs()
+	for {
+		if err := cp.Proc.Wait(); err != nil {
+			fmt.Println("[*]", cp.Pname, "Non zero exit: ", err)
 		}
-		RemoveProcess(cp)
-	}
-	if !cp.DetachF {
-		if _, ok := activeProcesses[cp.PID]; ok {
-			activeProcesses[cp.PID].IsAlive = false
+		status := cp.recordExit()
+		cp.StdOutWr.Close()
+		cp.StdErrWr.Close()
+		cp.runLock.Lock()
+		restart := !cp.KillSwitch && !cp.DetachF && (cp.recycle || cp.Conf.restarts(status))
+		cp.recycle = false
+		cp.runLock.Unlock()
+		if restart {
+			cp.setState(StateBackoff)
+		} else {
+			cp.setState(StateStopped)
+		}
+		close(cp.exited)
+		if !restart {
+			return
+		}
+		cp.stateLock.Lock()
+		if time.Since(cp.Timestamp) < cp.Conf.startSecs() {
+			cp.Failures++
+		} else {
+			cp.Failures = 0
+		}
+		cp.stateLock.Unlock()
+		for {
+			if max := cp.Conf.maxRetries(); max >= 0 && cp.Failures > max {
+				log.Println(cp.Pname, "failed to start", cp.Failures, "times in a row. Giving up. Start Explicitly")
+				cp.setState(StateFatal)
+				return
+			}
+			delay := cp.Conf.backoff(cp.Failures)
+			cp.stateLock.Lock()
+			cp.NextAttempt = time.Now().Add(delay)
+			cp.stateLock.Unlock()
+			if delay > 0 {
+				log.Println(cp.Pname, "exited. Restarting in", delay)
+			}
+			select {
+			case <-time.After(delay):
+			case <-cp.halt:
+			}
+			cp.stateLock.Lock()
+			cp.NextAttempt = time.Time{}
+			cp.stateLock.Unlock()
+
+			cp.runLock.Lock()
+			if cp.KillSwitch {
+				cp.setState(StateStopped)
+				cp.runLock.Unlock()
+				return
+			}
+			RemoveProcess(cp)
+			err := cp.Initialize(cp.Conf, 1)
+			AddProcess(cp)
+			cp.runLock.Unlock()
+			if err == nil {
+				break
+			}
+			log.Println(cp.Pname, "restart failed:", err)
+			cp.stateLock.Lock()
+			cp.Failures++
+			cp.stateLock.Unlock()
 		}
 	}
-	return nil
 }
 
 //AttachProcess invokes ps -ef and greps
@@ -151,9 +233,44 @@ func generateToken() string {
 }
 
 func listenRPC() (net.Listener, error) {
-	rpc.Register(new(Communicator))
-	rpc.HandleHTTP()
-	return net.Listen("tcp", ":"+strconv.Itoa(appConf.RPCPort))
+	server := rpc.NewServer()
+	server.Register(new(Communicator))
+	http.Handle(rpc.DefaultRPCPath, RPCAuth(server))
+	if err := listenSocket(server); err != nil {
+		log.Println("control socket:", err)
+	}
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(appConf.RPCPort))
+	if err != nil || appConf.RPCCertFile == "" {
+		return listener, err
+	}
+	conf, err := rpcTLSConfig()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return tls.NewListener(listener, conf), nil
+}
+
+//rpcTLSConfig loads the RPC certificate, and the client CA for mutual TLS
+func rpcTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(appConf.RPCCertFile, appConf.RPCKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if appConf.RPCClientCAFile == "" {
+		return conf, nil
+	}
+	pem, err := ioutil.ReadFile(appConf.RPCClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	conf.ClientCAs = x509.NewCertPool()
+	if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("[*] No certificates found in " + appConf.RPCClientCAFile)
+	}
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+	return conf, nil
 }
 
 func main() {
@@ -194,13 +311,13 @@ func main() {
 	log.Println(1)
 	activeProcesses = make(map[int]*ChildProcess)
 
-	for _, job := range jobs {
-		go RegisterProcess(job, 0)
-	}
+	StartJobs()
+	go sampleMetrics()
 
 	listener, rpcErr := listenRPC()
 	if rpcErr != nil {
-		log.Println(err.Error())
+		log.Println(rpcErr)
+		fmt.Println("[*] Fatal:", rpcErr)
 		return
 	}
 
@@ -210,14 +327,21 @@ func main() {
 	}()
 
 	router := mux.NewRouter()
+	APIRoutes(router)
+	router.HandleFunc("/metrics", CheckMetricsAuth(Metrics))
 	router.HandleFunc("/{token}", CheckToken(Default))
 	router.HandleFunc("/{token}/{pid}/stats", CheckToken(WithProcess(Stats)))
+	router.HandleFunc("/{token}/{pid}/stats/history", CheckToken(WithProcess(StatsHistory)))
 	router.HandleFunc("/{token}/{pid}/kill", CheckToken(WithProcess(Kill)))
 	router.HandleFunc("/{token}/{pid}/start", CheckToken(WithProcess(Start)))
 	router.HandleFunc("/{token}/{pid}/restart", CheckToken(WithProcess(Restart)))
 	router.HandleFunc("/{token}/{pid}/stdout", CheckToken(WithProcess(StdOut)))
 	router.HandleFunc("/{token}/{pid}/stderr", CheckToken(WithProcess(StdErr)))
+	router.HandleFunc("/{token}/{pid}/logs", CheckToken(WithProcess(Logs)))
+	router.HandleFunc("/{token}/{pid}/stdout/stream", CheckToken(WithProcess(StdOutStream)))
+	router.HandleFunc("/{token}/{pid}/stderr/stream", CheckToken(WithProcess(StdErrStream)))
 	router.HandleFunc("/{token}/{pid}/detach", CheckToken(WithProcess(Detach)))
+	router.HandleFunc("/{token}/{pid}/scale/{n}", CheckToken(WithProcess(Scale)))
 	//ability to detach process
 	switch appConf.Protocol {
 	case "http":
@@ -0,0 +1,92 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"github.com/ziscky/zist/zistrpc"
+	"os"
+	"testing"
+	"time"
+)
+
+//TestInfoWhileBackingOff reads a flapping process while its supervisor restarts it, run with -race
+func TestInfoWhileBackingOff(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	activeProcesses = make(map[int]*ChildProcess)
+	job := Job{Name: "flap", Template: "flap", Path: "/bin/false", Restart: RestartOnFailure, MaxRetries: -1, Backoff: 1}
+	go RegisterProcess(job, 0)
+
+	var proc *ChildProcess
+	sawBackoff := false
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if procs := jobProcesses("flap"); len(procs) > 0 {
+			proc = procs[0]
+		}
+		if proc == nil {
+			continue
+		}
+		info := proc.Info()
+		proc.Running()
+		startStopped(proc)
+		if info.State == string(StateBackoff) && info.NextAttempt != "" && info.LastExit != nil {
+			sawBackoff = true
+		}
+	}
+	if proc == nil {
+		t.Fatal("flap was never started")
+	}
+	if err := proc.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if !sawBackoff {
+		t.Fatalf("flap never backed off, last info %+v", proc.Info())
+	}
+	if info := proc.Info(); info.NumRestarts == 0 || info.Failures == 0 {
+		t.Fatalf("restarts and failures weren't counted: %+v", info)
+	}
+}
+
+//TestExecProbeUsesJob checks the first exec probe, run right away, already sees the job
+func TestExecProbeUsesJob(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	activeProcesses = make(map[int]*ChildProcess)
+	job := Job{Name: "probed", Template: "probed", Path: "/bin/sleep", Args: "5",
+		Env:         map[string]string{"PROBE_VAR": "from-job"},
+		HealthCheck: &Probe{Type: "exec", Command: `sh -c 'echo $PROBE_VAR'`}}
+	go RegisterProcess(job, 0)
+
+	var proc *ChildProcess
+	var info zistrpc.ProcessInfo
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if procs := jobProcesses("probed"); len(procs) > 0 {
+			proc = procs[0]
+			if info = proc.Info(); info.LastProbe != nil {
+				break
+			}
+		}
+	}
+	if proc == nil {
+		t.Fatal("probed was never started")
+	}
+	defer proc.Kill()
+	if info.LastProbe == nil || info.LastProbe.Output != "from-job" {
+		t.Fatalf("probe didn't run with the job environment: %+v", info.LastProbe)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//DefaultSyslogAddress is the local syslog daemon socket
+	DefaultSyslogAddress = "/dev/log"
+	//SyslogTimeout bounds connecting and writing to the syslog daemon
+	SyslogTimeout = 5 * time.Second
+	//SyslogQueueSize is how many lines wait to be sent before new ones are dropped
+	SyslogQueueSize = 1000
+)
+
+//SyslogConfig forwards the process output to a syslog daemon
+type SyslogConfig struct {
+	//Address is a unix socket path (default /dev/log) or udp://host:port, tcp://host:port, unix:///path
+	Address string
+	//Facility i.e daemon (default), user, local0 ... local7
+	Facility string
+	//Tag is the syslog APP-NAME, the process name by default
+	Tag string
+	//StdoutSeverity and StderrSeverity default to info and err
+	StdoutSeverity string
+	StderrSeverity string
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+//Check validates the syslog configuration
+func (sc *SyslogConfig) Check() error {
+	if _, _, err := sc.endpoint(); err != nil {
+		return err
+	}
+	if _, err := sc.priority(sc.StdoutSeverity, "info"); err != nil {
+		return err
+	}
+	_, err := sc.priority(sc.StderrSeverity, "err")
+	return err
+}
+
+//endpoint splits Address into the network and address to dial,
+//unix means a datagram socket falling back to a stream one
+func (sc *SyslogConfig) endpoint() (string, string, error) {
+	if sc.Address == "" {
+		return "unix", DefaultSyslogAddress, nil
+	}
+	if strings.HasPrefix(sc.Address, "/") {
+		return "unix", sc.Address, nil
+	}
+	u, err := url.Parse(sc.Address)
+	if err != nil {
+		return "", "", errors.New("[*] Invalid syslog Address " + sc.Address + ": " + err.Error())
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", errors.New("[*] Invalid syslog Address " + sc.Address + ", use " + u.Scheme + "://host:port")
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		return "unix", u.Path, nil
+	}
+	return "", "", errors.New("[*] Invalid syslog Address " + sc.Address + ", use a socket path or udp://, tcp://, unix://")
+}
+
+//priority is the RFC 5424 PRI of the facility and severity
+func (sc *SyslogConfig) priority(severity, def string) (int, error) {
+	facility := sc.Facility
+	if facility == "" {
+		facility = "daemon"
+	}
+	f, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return 0, errors.New("[*] Unknown syslog facility " + facility)
+	}
+	if severity == "" {
+		severity = def
+	}
+	s, ok := syslogSeverities[strings.ToLower(severity)]
+	if !ok {
+		return 0, errors.New("[*] Unknown syslog severity " + severity)
+	}
+	return f*8 + s, nil
+}
+
+//SyslogWriter sends lines to a syslog daemon as RFC 5424 messages.
+//Lines are queued and sent from a goroutine so a slow or unreachable daemon never holds up
+//the process output, lines that don't fit in the queue are dropped and counted.
+//It connects on the first line and reconnects after errors
+type SyslogWriter struct {
+	network  string
+	address  string
+	tag      string
+	hostname string
+	outPri   int
+	errPri   int
+	conn     net.Conn
+	//stream is set for connections that need message framing
+	stream bool
+	//failed is set after a write error has been logged until a write succeeds again
+	failed bool
+	queue  chan string
+	//dropped counts the lines dropped on a full queue, reported is the part already logged
+	dropped  uint64
+	reported uint64
+	closed   bool
+	lock     sync.Mutex
+}
+
+//openSyslog creates the syslog writer of the job, nil when the job doesn't forward to syslog
+func (job Job) openSyslog() (*SyslogWriter, error) {
+	sc := job.Syslog
+	if sc == nil {
+		return nil, nil
+	}
+	network, address, err := sc.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	sw := &SyslogWriter{network: network, address: address, tag: sc.Tag}
+	if sw.tag == "" {
+		sw.tag = job.Name
+	}
+	if sw.outPri, err = sc.priority(sc.StdoutSeverity, "info"); err != nil {
+		return nil, err
+	}
+	if sw.errPri, err = sc.priority(sc.StderrSeverity, "err"); err != nil {
+		return nil, err
+	}
+	if sw.hostname, err = os.Hostname(); err != nil || sw.hostname == "" {
+		sw.hostname = "-"
+	}
+	sw.queue = make(chan string, SyslogQueueSize)
+	go sw.run()
+	return sw, nil
+}
+
+func (sw *SyslogWriter) connect() error {
+	var err error
+	switch sw.network {
+	case "unix":
+		if sw.conn, err = net.DialTimeout("unixgram", sw.address, SyslogTimeout); err == nil {
+			sw.stream = false
+			return nil
+		}
+		sw.conn, err = net.DialTimeout("unix", sw.address, SyslogTimeout)
+		sw.stream = true
+	default:
+		sw.conn, err = net.DialTimeout(sw.network, sw.address, SyslogTimeout)
+		sw.stream = sw.network == "tcp"
+	}
+	return err
+}
+
+//Write queues a line of the stream, stdout or stderr, written by process pid.
+//It never blocks, the line is dropped when the queue is full
+func (sw *SyslogWriter) Write(stream string, pid int, text string) {
+	pri := sw.outPri
+	if stream == "stderr" {
+		pri = sw.errPri
+	}
+	msg := Format5424(pri, time.Now(), sw.hostname, sw.tag, strconv.Itoa(pid), stream, text)
+
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if sw.closed {
+		return
+	}
+	select {
+	case sw.queue <- msg:
+	default:
+		atomic.AddUint64(&sw.dropped, 1)
+	}
+}
+
+//Dropped is the number of lines dropped because the queue was full
+func (sw *SyslogWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&sw.dropped)
+}
+
+//run sends the queued lines until the writer is closed. After Close the
+//lines left are only sent while the daemon takes them
+func (sw *SyslogWriter) run() {
+	for msg := range sw.queue {
+		err := sw.send(msg)
+		if dropped := sw.Dropped(); dropped > sw.reported {
+			log.Println("syslog:", sw.tag, "dropped", dropped-sw.reported, "lines, the queue was full")
+			sw.reported = dropped
+		}
+		if err == nil {
+			continue
+		}
+		if !sw.failed {
+			log.Println("syslog:", err)
+			sw.failed = true
+		}
+		sw.lock.Lock()
+		closed := sw.closed
+		sw.lock.Unlock()
+		if closed {
+			break
+		}
+	}
+	if sw.conn != nil {
+		sw.conn.Close()
+		sw.conn = nil
+	}
+}
+
+//send writes a message, retrying once on a fresh connection as the daemon may have restarted
+func (sw *SyslogWriter) send(msg string) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if sw.conn == nil {
+			if err = sw.connect(); err != nil {
+				sw.conn = nil
+				continue
+			}
+		}
+		sw.conn.SetWriteDeadline(time.Now().Add(SyslogTimeout))
+		if _, err = sw.conn.Write(sw.frame(msg)); err == nil {
+			sw.failed = false
+			return nil
+		}
+		sw.conn.Close()
+		sw.conn = nil
+	}
+	return err
+}
+
+//frame prefixes stream messages with their length (RFC 6587 octet counting),
+//datagrams carry one message each
+func (sw *SyslogWriter) frame(msg string) []byte {
+	if !sw.stream {
+		return []byte(msg)
+	}
+	if sw.network == "unix" {
+		return []byte(msg + "\n")
+	}
+	return []byte(strconv.Itoa(len(msg)) + " " + msg)
+}
+
+//Close stops queueing lines, the connection is closed once the queued lines are sent
+func (sw *SyslogWriter) Close() error {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	if !sw.closed {
+		sw.closed = true
+		close(sw.queue)
+	}
+	return nil
+}
+
+//Format5424 formats a syslog message as RFC 5424 without structured data
+func Format5424(pri int, t time.Time, hostname, appName, procID, msgID, msg string) string {
+	return "<" + strconv.Itoa(pri) + ">1 " + t.Format("2006-01-02T15:04:05.000000Z07:00") + " " +
+		syslogField(hostname, 255) + " " + syslogField(appName, 48) + " " +
+		syslogField(procID, 128) + " " + syslogField(msgID, 32) + " - " + msg
+}
+
+//syslogField makes a header field printable ASCII without spaces of at most max characters, - when empty
+func syslogField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+//writeSyslog forwards a line to syslog if the job does
+func writeSyslog(sw *SyslogWriter, stream string, pid int, line string) {
+	if sw == nil {
+		return
+	}
+	sw.Write(stream, pid, line)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat5424(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 9, 30, 0, 123456000, time.UTC)
+	got := Format5424(30, ts, "web 1", "", "42", "stdout", "hello world")
+	want := "<30>1 2026-10-18T09:30:00.123456Z web_1 - 42 stdout - hello world"
+	if got != want {
+		t.Fatalf("Format5424 = %q, want %q", got, want)
+	}
+	long := Format5424(30, ts, "h", strings.Repeat("a", 60), "1", "stdout", "x")
+	if !strings.Contains(long, " "+strings.Repeat("a", 48)+" 1 ") {
+		t.Fatalf("APP-NAME not cut to 48 characters: %q", long)
+	}
+}
+
+//readFrame reads an RFC 6587 octet counted message
+func readFrame(r *bufio.Reader) (string, error) {
+	size, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	job := Job{Name: "app1", Syslog: &SyslogConfig{Address: "tcp://" + ln.Addr().String(), Facility: "local0"}}
+	sw, err := job.openSyslog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	sw.Write("stdout", 42, "first line")
+	sw.Write("stderr", 42, "second\nline")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []struct{ prefix, suffix string }{
+		{"<134>1 ", " app1 42 stdout - first line"},
+		{"<131>1 ", " app1 42 stderr - second\nline"},
+	} {
+		msg, err := readFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(msg, want.prefix) || !strings.HasSuffix(msg, want.suffix) {
+			t.Fatalf("got %q, want %q...%q", msg, want.prefix, want.suffix)
+		}
+	}
+}
+
+func TestSyslogUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	job := Job{Name: "app1", Syslog: &SyslogConfig{Address: path, Tag: "web"}}
+	sw, err := job.openSyslog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	sw.Write("stdout", 7, "hello")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<30>1 ") || !strings.HasSuffix(msg, " web 7 stdout - hello") {
+		t.Fatalf("datagram %q isn't an unframed RFC 5424 message", msg)
+	}
+}
+
+func TestSyslogQueueFull(t *testing.T) {
+	sw := &SyslogWriter{tag: "app1", hostname: "-", queue: make(chan string, 1)}
+	sw.Write("stdout", 1, "kept")
+	sw.Write("stdout", 1, "dropped")
+	if sw.Dropped() != 1 {
+		t.Fatalf("Dropped = %d, want 1", sw.Dropped())
+	}
+	sw.Close()
+	sw.Write("stdout", 1, "after close")
+	if len(sw.queue) != 1 {
+		t.Fatalf("queue holds %d lines, want 1", len(sw.queue))
+	}
+}
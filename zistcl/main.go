@@ -19,11 +19,24 @@ package main
 
 import(
     "net/rpc"
+    "crypto/tls"
+    "crypto/x509"
+    "net"
+    "net/http"
+    "io"
+    "io/ioutil"
+    "bufio"
+    "strings"
     "os"
     "log"
     "strconv"
     "fmt"
+    "flag"
+    "errors"
+    "encoding/json"
+    "time"
     "github.com/BurntSushi/toml"
+    "github.com/ziscky/zist/zistrpc"
 )
 
 
@@ -42,26 +55,31 @@ type ZistConfig struct{
     HTTPPort int
     RPCPort int
     Token string
+    RPCCertFile string
+    RPCKeyFile string
+    RPCClientCAFile string
+    Socket string
 }
 
 var appConf ZistConfig
 
-//Kill instructs zistd to terminate
-func Kill(client *rpc.Client,flag int) (string,error){
-    var status string
-    return status,client.Call("Communicator.Kill",flag,&status)
+//Kill instructs zistd to terminate, detach leaves the monitored processes running
+func Kill(client *rpc.Client,detach bool) error{
+    var reply zistrpc.MessageReply
+    return client.Call(zistrpc.Service+".Kill",zistrpc.KillArgs{Detach:detach},&reply)
 }
 
 //Reload causes zistd to reload the monitored process configs
 func Reload(client *rpc.Client) (string,error){
-    var status string
-    return status,client.Call("Communicator.Reload",0,&status)
+    var reply zistrpc.MessageReply
+    err := client.Call(zistrpc.Service+".Reload",0,&reply)
+    return reply.Message,err
 }
 
 //DStatus inquires the status of zistd
 func DStatus(client *rpc.Client) (string,error){
     var status bool
-    if err := client.Call("Communicator.Status",0,&status); err !=nil{
+    if err := client.Call(zistrpc.Service+".Status",0,&status); err !=nil{
         return "Error",err
     }
     if status{
@@ -73,82 +91,405 @@ func DStatus(client *rpc.Client) (string,error){
 //GetLog gets the contents of zistd log file
 func GetLog(client *rpc.Client) (string,error){
      var status string
-     return status,client.Call("Communicator.ReadLog",0,&status)
+     return status,client.Call(zistrpc.Service+".ReadLog",0,&status)
 }
 
 //ClearLog clears the contents of the zistd log file
 func ClearLog(client *rpc.Client) (string,error){
-    var status string
-    return status,client.Call("Communicator.ClearLog",0,&status)
+    var reply zistrpc.MessageReply
+    err := client.Call(zistrpc.Service+".ClearLog",0,&reply)
+    return reply.Message,err
 }
 
 //ProcAll gets the details of all monitored processes
-func ProcAll(client *rpc.Client) (string,error){
-    var status string
-    return status,client.Call("Communicator.All",0,&status)
+func ProcAll(client *rpc.Client) ([]zistrpc.ProcessInfo,error){
+    var procs []zistrpc.ProcessInfo
+    return procs,client.Call(zistrpc.Service+".All",0,&procs)
 }
 
+//fail prints the message of a failed call and exits with a non zero status
+func fail(err error){
+    fmt.Println("[*]",zistrpc.ParseError(err).Message)
+    os.Exit(1)
+}
+
+//printJSON prints a reply as indented JSON
+func printJSON(v interface{}){
+    b,err := json.MarshalIndent(v,"","  ")
+    if err != nil{
+        fail(err)
+    }
+    fmt.Println(string(b))
+}
+
+//printLines prints captured output lines
+func printLines(lines []zistrpc.LogLine){
+    for _,line := range lines{
+        fmt.Println(line.Seq,line.Text)
+    }
+}
+
+
+//TLS flags
+var cacert,cert,key string
+
+//tlsFlags takes --cacert, --cert and --key off os.Args
+//so the positional argument parsing stays the same
+func tlsFlags() error{
+    args := []string{os.Args[0]}
+    for i := 1; i < len(os.Args); i++{
+        name,value := os.Args[i],""
+        if eq := strings.Index(name,"="); eq > 0{
+            name,value = name[:eq],name[eq+1:]
+        }
+        var dest *string
+        switch name{
+        case "--cacert","-cacert":
+            dest = &cacert
+        case "--cert","-cert":
+            dest = &cert
+        case "--key","-key":
+            dest = &key
+        default:
+            args = append(args,os.Args[i])
+            continue
+        }
+        if value == "" && !strings.Contains(os.Args[i],"="){
+            if i+1 == len(os.Args){
+                return errors.New("[*] "+name+" needs a file")
+            }
+            i++
+            value = os.Args[i]
+        }
+        *dest = value
+    }
+    os.Args = args
+    return nil
+}
+
+//tlsConfig builds the TLS config to reach zistd at host, nil for plain TCP.
+//--cacert verifies zistd, --cert and --key are the client certificate for mutual TLS.
+//Local connections use TLS when conf.toml has RPCCertFile and trust it unless --cacert is given
+func tlsConfig(host string,localConn bool) (*tls.Config,error){
+    ca := cacert
+    if ca == "" && localConn{
+        ca = appConf.RPCCertFile
+    }
+    if ca == "" && cert == ""{
+        return nil,nil
+    }
+    conf := &tls.Config{ServerName:host,MinVersion:tls.VersionTLS12}
+    if ca != ""{
+        pem,err := ioutil.ReadFile(ca)
+        if err != nil{
+            return nil,err
+        }
+        conf.RootCAs = x509.NewCertPool()
+        if !conf.RootCAs.AppendCertsFromPEM(pem){
+            return nil,errors.New("[*] No certificates found in "+ca)
+        }
+    }
+    if cert != "" || key != ""{
+        if cert == "" || key == ""{
+            return nil,errors.New("[*] --cert and --key go together")
+        }
+        pair,err := tls.LoadX509KeyPair(cert,key)
+        if err != nil{
+            return nil,err
+        }
+        conf.Certificates = []tls.Certificate{pair}
+    }
+    return conf,nil
+}
 
-//VerifyToken authenticates the zistcl request to zistd
-func VerifyToken(client *rpc.Client,token string) (bool,error){
-    var valid bool
-    if err := client.Call("Communicator.VerifyToken",token,&valid); err != nil{
-        return false,err
+//dialRPC connects to zistd at address, authenticating the connection with token.
+//zistd refuses every call on connections without a valid token
+//tlsConf connects over TLS when it's not nil
+func dialRPC(address,token string,tlsConf *tls.Config) (*rpc.Client,error){
+    var conn net.Conn
+    var err error
+    if tlsConf != nil{
+        conn,err = tls.Dial("tcp",address,tlsConf)
+    }else{
+        conn,err = net.Dial("tcp",address)
     }
-    return valid,nil
+    if err != nil{
+        return nil,err
+    }
+    client,err := connectRPC(conn,token)
+    if err != nil && tlsConf == nil && !strings.HasPrefix(err.Error(),"[*]"){
+        return nil,errors.New("[*] "+err.Error()+", if zistd serves RPC over TLS connect with --cacert")
+    }
+    return client,err
+}
+
+//dialSocket connects to the zistd control socket, zistd authorizes the caller by its uid and gid
+func dialSocket(path string) (*rpc.Client,error){
+    conn,err := net.Dial("unix",path)
+    if err != nil{
+        return nil,err
+    }
+    return connectRPC(conn,"")
 }
 
-//ProcStatus gets the process info of a particular process 
-//gets back a json string
-func ProcStatus(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessStatus",pname,&status)
+//connectRPC sets up RPC over conn, sending token when it's not empty.
+//zistd's refusals come back as [*] errors
+func connectRPC(conn net.Conn,token string) (*rpc.Client,error){
+    req := "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\r\n"
+    if token != ""{
+        req += "Authorization: Bearer "+token+"\r\n"
+    }
+    io.WriteString(conn,req+"\r\n")
+    resp,err := http.ReadResponse(bufio.NewReader(conn),&http.Request{Method:"CONNECT"})
+    if err == nil && resp.Status == "200 Connected to Go RPC"{
+        return rpc.NewClient(conn),nil
+    }
+    conn.Close()
+    if err != nil{
+        return nil,err
+    }
+    body,_ := ioutil.ReadAll(io.LimitReader(resp.Body,512))
+    return nil,errors.New("[*] "+resp.Status+": "+strings.TrimSpace(string(body)))
+}
+
+//localSocket is the control socket set in /etc/zist/conf.toml, or the default one.
+//The config may not be readable by callers that are allowed on the socket
+func localSocket() string{
+    var conf ZistConfig
+    toml.DecodeFile("/etc/zist/conf.toml",&conf)
+    switch conf.Socket{
+    case "":
+        return "/run/zist/zistd.sock"
+    case "none":
+        return ""
+    }
+    return conf.Socket
+}
+
+//ProcStatus gets the process info of a particular process with its masked environment
+func ProcStatus(client *rpc.Client,pname string) (zistrpc.ProcessInfo,error){
+    var info zistrpc.ProcessInfo
+    return info,client.Call(zistrpc.Service+".ProcessStatus",zistrpc.ProcessArgs{Name:pname},&info)
+}
+
+//procCall calls a zistd method that acts on a process by name and returns its message
+func procCall(client *rpc.Client,method,pname string) (string,error){
+    var reply zistrpc.MessageReply
+    err := client.Call(zistrpc.Service+"."+method,zistrpc.ProcessArgs{Name:pname},&reply)
+    return reply.Message,err
 }
 
 //ProcStart starts a  monitored process by name
 func ProcStart(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessStart",pname,&status)
+    return procCall(client,"ProcessStart",pname)
 }
 
 //ProcRestart restarts a monitored process by name
 func ProcRestart(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessRestart",pname,&status)
+    return procCall(client,"ProcessRestart",pname)
 }
 
 //ProcDetach instructs zistd to detach a monitored process
 func ProcDetach(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessDetach",pname,&status)
+    return procCall(client,"ProcessDetach",pname)
 }
 
 //ProcStop instructs zistd to stop a monitored process by name
 func ProcStop(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessStop",pname,&status)
+    return procCall(client,"ProcessStop",pname)
 }
 
-//ProcStderr gets the process stderr by name
-func ProcStderr(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessStdErr",pname,&status)
+//ProcStderr gets the process stderr by name after sequence number since
+func ProcStderr(client *rpc.Client,pname string,since uint64) ([]zistrpc.LogLine,error){
+    var reply zistrpc.LogReply
+    err := client.Call(zistrpc.Service+".ProcessStdErr",zistrpc.LogArgs{Name:pname,Since:since},&reply)
+    return reply.Lines,err
 }
 
-//ProcStdout gets the stdout of a monitored process by name
-func ProcStdout(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessStdOut",pname,&status)
+//ProcStdout gets the stdout of a monitored process by name after sequence number since
+func ProcStdout(client *rpc.Client,pname string,since uint64) ([]zistrpc.LogLine,error){
+    var reply zistrpc.LogReply
+    err := client.Call(zistrpc.Service+".ProcessStdOut",zistrpc.LogArgs{Name:pname,Since:since},&reply)
+    return reply.Lines,err
 }
 
-//ProcStats gets the stats of a monitored process by name
-func ProcStats(client *rpc.Client,pname string) (string,error){
-    var status string
-    return status,client.Call("Communicator.ProcessStats",pname,&status)
+//ProcStats gets the stats of a monitored process tree by name
+func ProcStats(client *rpc.Client,pname string) (*zistrpc.ProcStats,error){
+    var stats zistrpc.ProcStats
+    return &stats,client.Call(zistrpc.Service+".ProcessStats",zistrpc.ProcessArgs{Name:pname},&stats)
 }
 
+//ProcStatsHistory prints the stats history of a monitored process by name
+//--history is how far back to go, a duration i.e 1h or an RFC3339 time
+func ProcStatsHistory(client *rpc.Client,pname string,args []string) error{
+    fs := flag.NewFlagSet("stats",flag.ContinueOnError)
+    history := fs.String("history","1h","how far back to go, a duration i.e 1h or an RFC3339 time")
+    if err := fs.Parse(args); err != nil{
+        return err
+    }
+    since,err := logTime(*history)
+    if err != nil{
+        return err
+    }
+    var report zistrpc.MetricsReport
+    if err := client.Call(zistrpc.Service+".ProcessStatsHistory",zistrpc.HistoryArgs{Name:pname,Since:since},&report); err != nil{
+        return err
+    }
+    fmt.Printf("%-20s %8s %10s %6s %7s\n","TIME","CPU%","RSS","FDS","THREADS")
+    for _,p := range report.Points{
+        fmt.Printf("%-20s %8.1f %10s %6.0f %7.0f\n",p.Time.Local().Format("2006-01-02 15:04:05"),p.CPU.Avg,humanBytes(uint64(p.RSS.Avg)),p.FDs.Avg,p.Threads.Avg)
+    }
+    s := report.Summary
+    fmt.Println("\n[*]",s.Samples,"samples every",report.Interval,"since",since.Local().Format("2006-01-02 15:04:05"))
+    fmt.Printf("%-8s %10s %10s %10s\n","","MIN","AVG","MAX")
+    fmt.Printf("%-8s %10.1f %10.1f %10.1f\n","CPU%",s.CPU.Min,s.CPU.Avg,s.CPU.Max)
+    fmt.Printf("%-8s %10s %10s %10s\n","RSS",humanBytes(uint64(s.RSS.Min)),humanBytes(uint64(s.RSS.Avg)),humanBytes(uint64(s.RSS.Max)))
+    fmt.Printf("%-8s %10.0f %10.1f %10.0f\n","FDS",s.FDs.Min,s.FDs.Avg,s.FDs.Max)
+    fmt.Printf("%-8s %10.0f %10.1f %10.0f\n","THREADS",s.Threads.Min,s.Threads.Avg,s.Threads.Max)
+    return nil
+}
 
- var arg1,arg2,arg3,arg4 string
+//ProcTree prints the process tree of a monitored process by name with the usage of each process
+func ProcTree(client *rpc.Client,pname string) error{
+    root,err := ProcStats(client,pname)
+    if err != nil{
+        return err
+    }
+    fmt.Printf("%-8s %6s %10s %7s %5s  %s\n","PID","CPU%","RSS","THREADS","FDS","COMMAND")
+    printTree(root,0)
+    if root.Total != nil{
+        fmt.Printf("%-8s %6.1f %10s %7d %5d\n","TOTAL",root.Total.CPUPercent,humanBytes(root.Total.RSS),root.Total.Threads,root.Total.FDs)
+    }
+    return nil
+}
+
+func printTree(node *zistrpc.ProcStats,depth int){
+    indent := ""
+    for i := 0; i < depth; i++{
+        indent += "  "
+    }
+    if depth > 0{
+        indent += "\\_ "
+    }
+    fmt.Printf("%-8d %6.1f %10s %7d %5d  %s%s\n",node.PID,node.CPUPercent,humanBytes(node.RSS),node.Threads,node.FDs,indent,node.Command)
+    for _,child := range node.Children{
+        printTree(child,depth+1)
+    }
+}
+
+//humanBytes formats a byte count i.e 1.5M
+func humanBytes(n uint64) string{
+    units := []string{"B","K","M","G","T"}
+    v := float64(n)
+    i := 0
+    for v >= 1024 && i < len(units)-1{
+        v /= 1024
+        i++
+    }
+    return strconv.FormatFloat(v,'f',1,64)+units[i]
+}
+
+//ProcLogRotate rotates the log files of a monitored process by name
+func ProcLogRotate(client *rpc.Client,pname string) (string,error){
+    return procCall(client,"ProcessLogRotate",pname)
+}
+
+//ProcTail prints the output of a monitored process by name
+//-f keeps printing new lines, --stderr tails stderr, --since starts after a sequence number
+//and --lines the number of lines to start with
+func ProcTail(client *rpc.Client,pname string,args []string) error{
+    fs := flag.NewFlagSet("tail",flag.ContinueOnError)
+    follow := fs.Bool("f",false,"keep printing new lines")
+    stderr := fs.Bool("stderr",false,"tail stderr instead of stdout")
+    since := fs.Uint64("since",0,"only lines after this sequence number")
+    lines := fs.Int("lines",10,"number of lines to start with")
+    if err := fs.Parse(args); err != nil{
+        return err
+    }
+    tail := zistrpc.TailArgs{Name:pname,Stderr:*stderr,Since:*since,Lines:*lines}
+    for{
+        var reply zistrpc.LogReply
+        if err := client.Call(zistrpc.Service+".ProcessTail",tail,&reply); err != nil{
+            return err
+        }
+        for _,line := range reply.Lines{
+            fmt.Println(line.Text)
+            tail.Since = line.Seq
+        }
+        if !*follow{
+            return nil
+        }
+        tail.Lines = 0
+        tail.Wait = 30
+    }
+}
+
+//logTime reads a --from/--to value, either RFC3339 or a duration meaning that long ago i.e 15m
+func logTime(value string) (time.Time,error){
+    if value == ""{
+        return time.Time{},nil
+    }
+    if d,err := time.ParseDuration(value); err == nil{
+        return time.Now().Add(-d),nil
+    }
+    return time.Parse(time.RFC3339,value)
+}
+
+//ProcLogs prints the merged stdout and stderr of a monitored process by name as JSON Lines
+//--from and --to limit the time range and --match filters lines by a regular expression
+func ProcLogs(client *rpc.Client,pname string,args []string) error{
+    fs := flag.NewFlagSet("logs",flag.ContinueOnError)
+    from := fs.String("from","","only lines after this time, RFC3339 or a duration ago i.e 15m")
+    to := fs.String("to","","only lines before this time, RFC3339 or a duration ago i.e 5m")
+    match := fs.String("match","","only lines matching this regular expression")
+    if err := fs.Parse(args); err != nil{
+        return err
+    }
+    logs := zistrpc.LogsArgs{Name:pname,Match:*match}
+    var err error
+    if logs.From,err = logTime(*from); err != nil{
+        return err
+    }
+    if logs.To,err = logTime(*to); err != nil{
+        return err
+    }
+    var reply zistrpc.LogReply
+    if err := client.Call(zistrpc.Service+".ProcessLogs",logs,&reply); err != nil{
+        return err
+    }
+    enc := json.NewEncoder(os.Stdout)
+    for _,line := range reply.Lines{
+        if err := enc.Encode(line); err != nil{
+            return err
+        }
+    }
+    return nil
+}
+
+//tailFlags takes the flags after a tail, logs or stats command off os.Args
+//so the positional argument parsing stays the same
+func tailFlags() []string{
+    pos := 4
+    if local(){
+        pos = 3
+    }
+    if len(os.Args) > pos && (os.Args[pos] == "tail" || os.Args[pos] == "logs" || os.Args[pos] == "stats"){
+        flags := os.Args[pos+1:]
+        os.Args = os.Args[:pos+1]
+        return flags
+    }
+    return nil
+}
+
+//ProcScale sets the number of instances of the job a monitored process belongs to
+func ProcScale(client *rpc.Client,pname string,n int) (string,error){
+    var reply zistrpc.MessageReply
+    err := client.Call(zistrpc.Service+".ProcessScale",zistrpc.ScaleArgs{Name:pname,N:n},&reply)
+    return reply.Message,err
+}
+
+
+ var arg1,arg2,arg3,arg4,arg5 string
 
 //setLocal assigns arguments if -l switch is present
 func setLocal() bool{
@@ -167,6 +508,13 @@ func setLocal() bool{
             arg3 = os.Args[2]
             arg4 = os.Args[3]
             break
+        case 5:
+            arg1 = os.Args[1]
+            arg2 = ""
+            arg3 = os.Args[2]
+            arg4 = os.Args[3]
+            arg5 = os.Args[4]
+            break
          default:
             printUsage()
             return false
@@ -195,6 +543,13 @@ func setRemote() bool{
         arg3 = os.Args[3]
         arg4 = os.Args[4]
         break
+    case 6:
+        arg1 = os.Args[1]
+        arg2 = os.Args[2]
+        arg3 = os.Args[3]
+        arg4 = os.Args[4]
+        arg5 = os.Args[5]
+        break
     default:
         printUsage()
         return false
@@ -207,6 +562,11 @@ func main() {
     //zist http:1.1.1.1:9000 ___________ cmds
     
     //set arguments
+    if err := tlsFlags(); err != nil{
+        fmt.Println(err)
+        return
+    }
+    tailArgs := tailFlags()
     if local(){
         if !setLocal(){
             return
@@ -227,12 +587,28 @@ func main() {
     
     //connect to rpc server
     if arg1 == "-l"{
-        if err := readLocalConfig(); err != nil{
-            return
+        //prefer the control socket, fall back to RPCPort and the token when it can't be reached
+        if sock := localSocket(); sock != ""{
+            client,err = dialSocket(sock)
+        }
+        if client == nil && (err == nil || !strings.HasPrefix(err.Error(),"[*]")){
+            if err := readLocalConfig(); err != nil{
+                return
+            }
+            var conf *tls.Config
+            if conf,err = tlsConfig("localhost",true); err == nil{
+                client,err = dialRPC(":"+strconv.Itoa(appConf.RPCPort),appConf.Token,conf)
+            }
         }
-        client,err = rpc.DialHTTP("tcp",":"+strconv.Itoa(appConf.RPCPort))
     }else{
-        client,err = rpc.DialHTTP("tcp",os.Args[1])
+        host,_,_ := net.SplitHostPort(os.Args[1])
+        if host == ""{
+            host = "localhost"
+        }
+        var conf *tls.Config
+        if conf,err = tlsConfig(host,false); err == nil{
+            client,err = dialRPC(os.Args[1],arg2,conf)
+        }
     }        
    
     if err != nil{
@@ -241,18 +617,6 @@ func main() {
     }
     defer client.Close()
     
-    //verify token
-    if !local(){
-        if verified,err := VerifyToken(client,arg2); err != nil{
-            log.Println(err)
-        }else{
-            if !verified{
-                fmt.Println("[*] Invalid RPC access token.")
-                return
-            }
-        }
-    }
-    
     
     if arg3 == ""{
         printUsage()
@@ -261,12 +625,11 @@ func main() {
     
  
     if arg3 == "all"{
-        stat,err := ProcAll(client)
+        procs,err := ProcAll(client)
         if err != nil{
-            log.Println(err)
-            return
+            fail(err)
         }
-        fmt.Println("[*]",stat)
+        printJSON(procs)
         return
     }
     
@@ -274,8 +637,7 @@ func main() {
     if arg3 == "status"{
         stat,err1 := DStatus(client)
         if err1 != nil{
-            fmt.Println(err1.Error())
-            return
+            fail(err1)
         }
         fmt.Println("[*]",stat)
         return
@@ -286,15 +648,13 @@ func main() {
         if arg4 == ""{
             stat,err := GetLog(client)
             if err != nil{
-                fmt.Println(err.Error())
-                return
+                fail(err)
             }
             fmt.Println("[*]",stat)
         }else if arg4 == "clear"{
             stat,err := ClearLog(client)
             if err != nil{
-                fmt.Println(err.Error())
-                return
+                fail(err)
             }
             fmt.Println("[*]",stat)
         }else{
@@ -308,12 +668,12 @@ func main() {
     if arg3 == "kill"{
         if arg4 == ""{
             fmt.Println("[*] Retaining monitored processes.Killing zistd.")
-            Kill(client,1)
+            Kill(client,true)
             return
         }
         if arg4 == "all"{
             fmt.Println("[*] Killing all processes together with zistd.")
-            Kill(client,0)    
+            Kill(client,false)    
         }else{
             fmt.Println("[*] Unknown command " + arg4)
         }
@@ -324,8 +684,7 @@ func main() {
     if arg3 == "reload"{
         stat,err := Reload(client)
         if err != nil{
-            log.Println(err)
-            return
+            fail(err)
         }
         fmt.Println("[*]",stat)
         return
@@ -339,75 +698,77 @@ func main() {
         return
     }
     
+    var stat string
     switch arg4 {
     case "status":
-        stat,err := ProcStatus(client,arg3)
+        info,err := ProcStatus(client,arg3)
         if err != nil{
-            log.Println(err)
-            return
+            fail(err)
         }
-        fmt.Println("[*]",stat)
+        printJSON(info)
         return
     case "start":
-        stat,err := ProcStart(client,arg3)
-        if err != nil{
-            log.Println(err)
-            return
-        }
-        fmt.Println("[*]",stat)
-        return
+        stat,err = ProcStart(client,arg3)
     case "stop":
-        stat,err := ProcStop(client,arg3)
-        if err != nil{
-            log.Println(err)
-            return
-        }
-        fmt.Println("[*]",stat)
-        return
+        stat,err = ProcStop(client,arg3)
      case "restart":
-        stat,err := ProcRestart(client,arg3)
+        stat,err = ProcRestart(client,arg3)
+      case "detach":
+        stat,err = ProcDetach(client,arg3)
+      case "stderr","stdout":
+        since,_ := strconv.ParseUint(arg5,10,64)
+        var lines []zistrpc.LogLine
+        if arg4 == "stderr"{
+            lines,err = ProcStderr(client,arg3,since)
+        }else{
+            lines,err = ProcStdout(client,arg3,since)
+        }
         if err != nil{
-            log.Println(err)
-            return
+            fail(err)
         }
-        fmt.Println("[*]",stat)
+        printLines(lines)
         return
-      case "detach":
-        stat,err := ProcDetach(client,arg3)
-        if err != nil{
-            log.Println(err)
+      case "stats":
+        if len(tailArgs) > 0{
+            if err := ProcStatsHistory(client,arg3,tailArgs); err != nil{
+                fail(err)
+            }
             return
         }
-        fmt.Println("[*]",stat)
-        return
-      case "stderr":
-        stat,err := ProcStderr(client,arg3)
+        stats,err := ProcStats(client,arg3)
         if err != nil{
-            log.Println(err)
-            return
+            fail(err)
         }
-        fmt.Println("[*]",stat)
+        printJSON(stats)
         return
-      case "stdout":
-        stat,err := ProcStdout(client,arg3)
+      case "tree":
+        err = ProcTree(client,arg3)
+      case "tail":
+        err = ProcTail(client,arg3,tailArgs)
+      case "logs":
+        err = ProcLogs(client,arg3,tailArgs)
+      case "logrotate":
+        stat,err = ProcLogRotate(client,arg3)
+      case "scale":
+        n,err := strconv.Atoi(arg5)
         if err != nil{
-            log.Println(err)
+            fmt.Println("[*] scale needs the number of instances i.e zistcl -l worker-0 scale 4")
             return
         }
-        fmt.Println("[*]",stat)
-        return
-      case "stats":
-        stat,err := ProcStats(client,arg3)
+        stat,err = ProcScale(client,arg3,n)
         if err != nil{
-            log.Println(err)
-            return
+            fail(err)
         }
-        fmt.Println("[*]",stat)
-        return
        default:
         fmt.Println("[*] Unknown command",arg4)
         return
     }
+    if err != nil{
+        fail(err)
+    }
+    if stat != ""{
+        fmt.Println("[*]",stat)
+    }
     
 }
 
@@ -448,6 +809,8 @@ func readLocalConfig() error{
 
 func printUsage(){
     fmt.Println(`Usage: zistcl host:port token [cmd] or zistcl -l [cmd] #for local connection
+        TLS: --cacert file - CA certificate to verify a zistd serving RPC over TLS
+             --cert file --key file - client certificate when zistd requires one (mutual TLS)
         CMDS: kill - kill zistd but detach monitored procs to continue running on their own
               kill all - kill zistd and monitored procs
               status - get status of zistd
@@ -463,9 +826,18 @@ func printUsage(){
               stop - stop the named process
               detach - detach the named process from zistd
               start - start the named process
-              stats - gets the named process stats
-              stderr - gets the named process stderr
-              stdout - gets the named process stdout
+              stats - gets the named process stats, with its child processes and the total of the tree
+              stats --history 1h - the named process tree cpu, memory, open files and threads sampled over the last hour
+                  with their min, avg and max
+              tree - lists the named process and its descendants with their cpu, memory, threads and open files
+              stderr [seq] - gets the named process stderr, only lines after sequence number seq if given
+              stdout [seq] - gets the named process stdout, only lines after sequence number seq if given
+              tail [-f] [--stderr] [--since seq] [--lines N] - print the last N (default 10) lines of the named process stdout,
+                  -f keeps following new lines, --stderr tails stderr, --since starts after a sequence number
+              logs [--from time] [--to time] [--match regex] - print the named process stdout and stderr merged in time order
+                  as JSON Lines tagged with stream, time, pid and generation. Times are RFC3339 or durations ago i.e 15m
+              logrotate - rotate the named process log files
+              scale N - run N instances of the named process's job, by instance or Name template
               example: zistcl -l app1 restart`)
    
 }
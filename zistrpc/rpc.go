@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+//Package zistrpc holds the RPC arguments, replies and errors shared by zistd and zistcl
+package zistrpc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Service is the name zistd registers its RPC methods under i.e zistrpc.Service+".ProcessStatus"
+const Service = "Communicator"
+
+//Error codes
+const (
+	//CodeNotFound is a process or job that doesn't exist
+	CodeNotFound = "not_found"
+	//CodeInvalid is a call with bad arguments
+	CodeInvalid = "invalid"
+	//CodeConflict is a call the process isn't in a state for, i.e starting a running process
+	CodeConflict = "conflict"
+	//CodeFailed is a call that failed, errors without a code are read back with it
+	CodeFailed = "failed"
+)
+
+//Error is an error with a code. net/rpc only carries error strings,
+//so it travels as "[code] message" and ParseError reads it back
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return "[" + e.Code + "] " + e.Message
+}
+
+//Errorf creates an error with a code
+func Errorf(code, format string, a ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+//NotFound is the error of calls naming a process that doesn't exist
+func NotFound(name string) error {
+	return &Error{Code: CodeNotFound, Message: "No such process " + name}
+}
+
+//ParseError reads the code and message of an error returned by a zistd call
+func ParseError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "[") {
+		if end := strings.Index(msg, "] "); end > 1 && !strings.ContainsAny(msg[1:end], " *") {
+			return &Error{Code: msg[1:end], Message: msg[end+2:]}
+		}
+	}
+	return &Error{Code: CodeFailed, Message: msg}
+}
+
+//IsNotFound checks if a zistd call failed because the process doesn't exist
+func IsNotFound(err error) bool {
+	return err != nil && ParseError(err).Code == CodeNotFound
+}
+
+//ProcessArgs names the process a call is about
+type ProcessArgs struct {
+	Name string
+}
+
+//KillArgs stops zistd, Detach leaves the monitored processes running
+type KillArgs struct {
+	Detach bool
+}
+
+//ScaleArgs is the job and the number of instances for ProcessScale
+type ScaleArgs struct {
+	Name string
+	N    int
+}
+
+//LogArgs selects the process and the output lines after sequence number Since
+type LogArgs struct {
+	Name  string
+	Since uint64
+}
+
+//TailArgs selects the output lines ProcessTail returns.
+//Lines > 0 with no Since returns the newest Lines lines right away, otherwise the call
+//returns the lines after Since, waiting up to Wait seconds for new ones
+type TailArgs struct {
+	Name   string
+	Stderr bool
+	Since  uint64
+	Lines  int
+	Wait   int
+}
+
+//LogsArgs selects the process and the lines of its unified log ProcessLogs returns
+type LogsArgs struct {
+	Name string
+	From time.Time
+	To   time.Time
+	//Match is a regular expression lines must match
+	Match string
+}
+
+//HistoryArgs selects the process and the samples after Since ProcessStatsHistory returns
+type HistoryArgs struct {
+	Name  string
+	Since time.Time
+}
+
+//MessageReply is the outcome of a call that changes something
+type MessageReply struct {
+	Message string `json:"message"`
+}
+
+//LogReply is captured process output
+type LogReply struct {
+	Lines []LogLine `json:"lines"`
+}
+
+//LogLine is a captured line of process output tagged with the stream it came from
+//and the PID and generation (start number) of the run that wrote it
+type LogLine struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Stream     string    `json:"stream"`
+	PID        int       `json:"pid"`
+	Generation int       `json:"generation"`
+	Text       string    `json:"text"`
+}
+
+//ExitStatus records the exit code or the terminating signal of a process
+type ExitStatus struct {
+	Code   int       `json:"code"`
+	Signal string    `json:"signal,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+//ProbeResult is the outcome of a single probe
+type ProbeResult struct {
+	OK       bool      `json:"ok"`
+	Output   string    `json:"output"`
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+}
+
+//ProcessInfo describes a monitored process
+type ProcessInfo struct {
+	PID         int    `json:"pid"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Args        string `json:"args"`
+	NumRestarts int    `json:"numrestarts"`
+	Generation  int    `json:"generation"`
+	TimeStarted string `json:"timestarted"`
+	TimeAlive   string `json:"timealive"`
+	State       string `json:"state"`
+	//Failures is the number of failed starts in a row, NextAttempt when the next restart is due
+	Failures    int          `json:"failures"`
+	NextAttempt string       `json:"nextattempt"`
+	LastExit    *ExitStatus  `json:"lastexit"`
+	Health      string       `json:"health"`
+	LastProbe   *ProbeResult `json:"lastprobe"`
+	Readiness   *ProbeResult `json:"readiness"`
+	//Env is the masked environment of the process, only set by ProcessStatus
+	Env map[string]string `json:"env,omitempty"`
+}
@@ -0,0 +1,162 @@
+/*
+Copyright (C) 2016  Eric Ziscky
+
+    This program is free software; you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation; either version 2 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License along
+    with this program; if not, write to the Free Software Foundation, Inc.,
+    51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+package zistrpc
+
+import "time"
+
+//ProcStats is the resource usage of a process read from /proc
+type ProcStats struct {
+	PID     int    `json:"pid"`
+	PPID    int    `json:"ppid"`
+	Command string `json:"command"`
+	//RSS and VSZ are the resident and virtual memory sizes in bytes
+	RSS uint64 `json:"rss"`
+	VSZ uint64 `json:"vsz"`
+	//MemPercent is RSS as a percentage of the total memory
+	MemPercent float64 `json:"mem"`
+	//CPUTime is the user and system cpu time used in seconds
+	CPUTime float64 `json:"cputime"`
+	//CPUPercent is the cpu used over the sampling window, 100 is a whole core
+	CPUPercent float64 `json:"cpu"`
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+	//ReadBytes and WriteBytes are the bytes read from and written to storage
+	ReadBytes  uint64 `json:"readbytes"`
+	WriteBytes uint64 `json:"writebytes"`
+	//VoluntaryCtxSwitches and InvoluntaryCtxSwitches count the context switches of the process
+	VoluntaryCtxSwitches   uint64    `json:"voluntaryctxswitches"`
+	InvoluntaryCtxSwitches uint64    `json:"involuntaryctxswitches"`
+	Time                   time.Time `json:"time"`
+	//Children are the stats of the processes the process started, read by zistd from /proc
+	Children []*ProcStats `json:"children,omitempty"`
+	//Total sums the stats of the whole process tree, set on the root by Stats
+	Total *ProcStats `json:"total,omitempty"`
+}
+
+//CPUPercentSince sets CPUPercent from the cpu time used since an earlier sample
+func (stats *ProcStats) CPUPercentSince(prev *ProcStats) {
+	elapsed := stats.Time.Sub(prev.Time).Seconds()
+	if elapsed <= 0 || stats.CPUTime < prev.CPUTime {
+		return
+	}
+	stats.CPUPercent = (stats.CPUTime - prev.CPUTime) * 100 / elapsed
+}
+
+//Walk calls fn for the process and all its descendants
+func (stats *ProcStats) Walk(fn func(*ProcStats)) {
+	fn(stats)
+	for _, child := range stats.Children {
+		child.Walk(fn)
+	}
+}
+
+//TreeCPUPercentSince sets the cpu percentage of every process in the tree from an earlier sample of it,
+//processes started since then are measured from their start
+func (stats *ProcStats) TreeCPUPercentSince(prev *ProcStats) {
+	before := map[int]*ProcStats{}
+	prev.Walk(func(p *ProcStats) { before[p.PID] = p })
+	stats.Walk(func(p *ProcStats) {
+		if b, ok := before[p.PID]; ok {
+			p.CPUPercentSince(b)
+		} else {
+			p.CPUPercentSince(&ProcStats{PID: p.PID, Time: prev.Time})
+		}
+	})
+}
+
+//SumTree adds up the stats of the whole tree into Total
+func (stats *ProcStats) SumTree() {
+	total := &ProcStats{PID: stats.PID, PPID: stats.PPID, Command: stats.Command, Time: stats.Time}
+	stats.Walk(func(p *ProcStats) {
+		total.RSS += p.RSS
+		total.VSZ += p.VSZ
+		total.MemPercent += p.MemPercent
+		total.CPUTime += p.CPUTime
+		total.CPUPercent += p.CPUPercent
+		total.Threads += p.Threads
+		total.FDs += p.FDs
+		total.ReadBytes += p.ReadBytes
+		total.WriteBytes += p.WriteBytes
+		total.VoluntaryCtxSwitches += p.VoluntaryCtxSwitches
+		total.InvoluntaryCtxSwitches += p.InvoluntaryCtxSwitches
+	})
+	stats.Total = total
+}
+
+//Range is the min, average and max of a metric over a sample
+type Range struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+//Merge combines the range of a sample of n points with one of m points
+func (r Range) Merge(n int, o Range, m int) Range {
+	if n == 0 {
+		return o
+	}
+	if o.Min < r.Min {
+		r.Min = o.Min
+	}
+	if o.Max > r.Max {
+		r.Max = o.Max
+	}
+	r.Avg = (r.Avg*float64(n) + o.Avg*float64(m)) / float64(n+m)
+	return r
+}
+
+//MetricPoint is the usage of a process tree at Time, or over the Samples samples
+//merged into it when it's downsampled or a summary
+type MetricPoint struct {
+	Time    time.Time `json:"time"`
+	Samples int       `json:"samples"`
+	CPU     Range     `json:"cpu"`
+	RSS     Range     `json:"rss"`
+	FDs     Range     `json:"fds"`
+	Threads Range     `json:"threads"`
+}
+
+//Merge adds the samples of o to mp
+func (mp *MetricPoint) Merge(o MetricPoint) {
+	mp.CPU = mp.CPU.Merge(mp.Samples, o.CPU, o.Samples)
+	mp.RSS = mp.RSS.Merge(mp.Samples, o.RSS, o.Samples)
+	mp.FDs = mp.FDs.Merge(mp.Samples, o.FDs, o.Samples)
+	mp.Threads = mp.Threads.Merge(mp.Samples, o.Threads, o.Samples)
+	mp.Samples += o.Samples
+}
+
+//Summarize merges points into their min, avg and max, timed at the first point
+func Summarize(points []MetricPoint) MetricPoint {
+	var summary MetricPoint
+	for i, mp := range points {
+		if i == 0 {
+			summary = mp
+			continue
+		}
+		summary.Merge(mp)
+	}
+	return summary
+}
+
+//MetricsReport is the stats history of a process after Since with its Summary
+type MetricsReport struct {
+	Since    time.Time     `json:"since"`
+	Interval string        `json:"interval"`
+	Summary  MetricPoint   `json:"summary"`
+	Points   []MetricPoint `json:"points"`
+}